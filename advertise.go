@@ -0,0 +1,325 @@
+package gossdp
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "time"
+)
+
+
+// Describes the server/service we wish to advertise
+type AdvertisableServer struct {
+    // The type of this service. In the URN it is pasted after the device-UUID.
+    //  It is what devices will search for
+    ServiceType             string
+    // The unique identifier of this device.
+    DeviceUuid              string
+    // The location of the service we are advertising. Eg. http://192.168.0.2:3434
+    Location                string
+    // The max number of seconds we want advertise and responses to be valid for.
+    //  0 means 1800, as UPnP recommends.
+    MaxAge                  int
+    // Extension headers to send with every NOTIFY and M-SEARCH response.
+    //  Eg. OPT, 01-NLS, X-User-Agent or SECURELOCATION.UPNP.ORG
+    ExtraHeaders            map[string]string
+    // The CONFIGID.UPNP.ORG of the device description. UpdateServer increments it.
+    ConfigId                int
+
+    target                  SearchTarget
+    status                  *serverStatus
+}
+
+// The parsed ServiceType
+func (a AdvertisableServer) Target() SearchTarget {
+    return toSearchTarget(a.ServiceType)
+}
+
+// Checks what goes into NT, ST, LOCATION and the extension headers.
+func (a AdvertisableServer) checkHeaders() error {
+    if err := checkHeader("NT", a.ServiceType); err != nil {
+        return err
+    }
+    return checkExtraHeaders(a.Location, a.ExtraHeaders)
+}
+
+// A device we advertise. One device UUID can offer many service types.
+type advertisedDevice struct {
+    uuid                    string
+    services                []*AdvertisableServer
+    bootId                  int
+    // fires when the device is next due to advertise
+    timer                   *time.Timer
+    // when timer fires. Guarded by statusLock
+    nextAdvertise           time.Time
+}
+
+// One of the NOTIFY messages a device sends.
+type notification struct {
+    target                  SearchTarget
+    ads                     *AdvertisableServer
+}
+
+// Every USN the device advertises: upnp:rootdevice and uuid:device-UUID once for
+// the device, then one per service type. The device level USNs describe the first service.
+func (d *advertisedDevice) notifications() []notification {
+    if len(d.services) == 0 {
+        return nil
+    }
+    root := d.services[0]
+    notes := []notification{
+        {SearchTarget{Kind: TargetRootDevice}, root},
+        {UuidTarget(d.uuid), root},
+    }
+    seen := map[string]bool{
+        notes[0].target.String(): true,
+        notes[1].target.String(): true,
+    }
+    for _, ads := range d.services {
+        if seen[ads.target.String()] {
+            continue
+        }
+        seen[ads.target.String()] = true
+        notes = append(notes, notification{ads.target, ads})
+    }
+    return notes
+}
+
+// Finds the service of the given type. -1 if the device does not offer it.
+func (d *advertisedDevice) service(serviceType string) int {
+    for i := range d.services {
+        if d.services[i].ServiceType == serviceType {
+            return i
+        }
+    }
+    return -1
+}
+
+// The headers of a NOTIFY. nts is one of ssdp:alive, ssdp:byebye or ssdp:update
+func (d *advertisedDevice) notifyHeaders(n notification, nts string) map[string]string {
+    heads := map[string]string{
+        "HOST": "239.255.255.250:1900",
+        "NT": n.target.String(),
+        "NTS": nts,
+        "USN": NewUSN(d.uuid, n.target).String(),
+        "BOOTID.UPNP.ORG": strconv.Itoa(d.bootId),
+        "CONFIGID.UPNP.ORG": strconv.Itoa(n.ads.ConfigId),
+    }
+    if nts != "ssdp:byebye" {
+        heads["LOCATION"] = n.ads.Location
+    }
+    if nts == "ssdp:alive" {
+        heads["CACHE-CONTROL"] = fmt.Sprintf("max-age=%d", n.ads.MaxAge)
+        heads["SERVER"] = serverName
+    }
+    addExtraHeaders(heads, n.ads.ExtraHeaders)
+    return heads
+}
+
+
+// Register a service to advertise
+// Call it once for every service type a device offers. Advertising the same device
+// and service type again replaces it.
+// This implementation will automatically re-advertise before maxAge expires.
+// See AdvertiseSchedule.
+// DeviceUuid must be a UUID, such as NewUuid or DeviceUuid make, or ErrInvalidDeviceUuid is returned.
+// ServiceType, Location and ExtraHeaders must be valid headers, or ErrInvalidHeader is returned.
+func (s *Ssdp) AdvertiseServer(ads AdvertisableServer) error {
+    if !ValidUuid(ads.DeviceUuid) {
+        return ErrInvalidDeviceUuid
+    }
+    if err := ads.checkHeaders(); err != nil {
+        return err
+    }
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning {
+        return errors.New("Not running. Can't advertise server")
+    }
+
+    adsPointer := &ads
+    adsPointer.ExtraHeaders = copyExtraHeaders(ads.ExtraHeaders)
+    adsPointer.MaxAge = orDefaultMaxAge(ads.MaxAge)
+    adsPointer.target = adsPointer.Target()
+    adsPointer.status = &serverStatus{}
+
+    d, ok := s.devices[ads.DeviceUuid]
+    if !ok {
+        d = &advertisedDevice{
+            uuid            : ads.DeviceUuid,
+            services        : []*AdvertisableServer{adsPointer},
+            bootId          : s.bootId,
+        }
+        s.devices[ads.DeviceUuid] = d
+        s.reportRegistrySize()
+        s.startDevice(d)
+        return nil
+    }
+    if i := d.service(ads.ServiceType); i >= 0 {
+        d.services[i] = adsPointer
+    } else {
+        d.services = append(d.services, adsPointer)
+        s.reportRegistrySize()
+    }
+    // the device is already being advertised, so announce the service straight away
+    s.sendNotify(adsPointer, d.notifyHeaders(notification{adsPointer.target, adsPointer}, "ssdp:alive"))
+    return nil
+}
+
+// Stops advertising a device, and every service it offers.
+// Sends ssdp:byebye for each of its USNs.
+func (s *Ssdp) RemoveServer(deviceUuid string) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning {
+        return
+    }
+
+    if d, ok := s.devices[deviceUuid]; ok {
+        s.removeDevice(d)
+    }
+}
+
+// Stops advertising one service of a device. Sends ssdp:byebye for its USN.
+// Removing the last service removes the device, as RemoveServer does.
+func (s *Ssdp) RemoveService(deviceUuid, serviceType string) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning {
+        return
+    }
+
+    d, ok := s.devices[deviceUuid]
+    if !ok {
+        return
+    }
+    i := d.service(serviceType)
+    if i < 0 {
+        return
+    }
+    if len(d.services) == 1 {
+        s.removeDevice(d)
+        return
+    }
+    ads := d.services[i]
+    d.services = append(d.services[:i:i], d.services[i+1:]...)
+    s.reportRegistrySize()
+    // the device level USNs live on with the remaining services
+    for _, n := range d.notifications() {
+        if n.target.String() == ads.target.String() {
+            return
+        }
+    }
+    s.sendNotify(ads, d.notifyHeaders(notification{ads.target, ads}, "ssdp:byebye"))
+}
+
+// Must hold interactionLock.
+func (s *Ssdp) removeDevice(d *advertisedDevice) {
+    d.timer.Stop()
+    for _, n := range d.notifications() {
+        s.sendNotify(n.ads, d.notifyHeaders(n, "ssdp:byebye"))
+    }
+    delete(s.devices, d.uuid)
+    s.reportRegistrySize()
+}
+
+// Changes an advertised device in place.
+// update is called for each service the device offers, and may change any field
+// but DeviceUuid. The CONFIGID is then incremented and the change announced:
+//  - a new ServiceType sends ssdp:byebye for the old USN
+//  - a new Location sends ssdp:update for every USN, moving to the next BOOTID
+//  - the device is then announced with ssdp:alive
+// Nothing changes if an update leaves an invalid header. See AdvertiseServer.
+func (s *Ssdp) UpdateServer(deviceUuid string, update func(*AdvertisableServer)) error {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning {
+        return errors.New("Not running. Can't update server")
+    }
+
+    d, ok := s.devices[deviceUuid]
+    if !ok {
+        return fmt.Errorf("No server advertised for device %s", deviceUuid)
+    }
+
+    updated := make([]AdvertisableServer, len(d.services))
+    for i, ads := range d.services {
+        updated[i] = *ads
+        updated[i].ExtraHeaders = copyExtraHeaders(ads.ExtraHeaders)
+        update(&updated[i])
+        if updated[i].DeviceUuid != deviceUuid {
+            return errors.New("UpdateServer can't change DeviceUuid. Remove the server and advertise it again")
+        }
+        if err := updated[i].checkHeaders(); err != nil {
+            return err
+        }
+        updated[i].ExtraHeaders = copyExtraHeaders(updated[i].ExtraHeaders)
+        updated[i].MaxAge = orDefaultMaxAge(updated[i].MaxAge)
+        updated[i].ConfigId = ads.ConfigId + 1
+        updated[i].target = updated[i].Target()
+    }
+
+    // remember what we advertised before, so we can say goodbye to what went away
+    type bye struct {
+        ads     *AdvertisableServer
+        heads   map[string]string
+    }
+    oldNotes := make(map[string]bye)
+    for _, n := range d.notifications() {
+        oldNotes[n.target.String()] = bye{n.ads, d.notifyHeaders(n, "ssdp:byebye")}
+    }
+    locationChanged := false
+    for i, ads := range d.services {
+        locationChanged = locationChanged || ads.Location != updated[i].Location
+        *ads = updated[i]
+    }
+    notes := d.notifications()
+    for _, n := range notes {
+        delete(oldNotes, n.target.String())
+    }
+
+    for _, b := range oldNotes {
+        s.sendNotify(b.ads, b.heads)
+    }
+    if locationChanged {
+        oldBootId := d.bootId
+        d.bootId++
+        for _, n := range notes {
+            heads := d.notifyHeaders(n, "ssdp:update")
+            heads["BOOTID.UPNP.ORG"] = strconv.Itoa(oldBootId)
+            heads["NEXTBOOTID.UPNP.ORG"] = strconv.Itoa(d.bootId)
+            s.sendNotify(n.ads, heads)
+        }
+    }
+    for _, n := range notes {
+        s.sendNotify(n.ads, d.notifyHeaders(n, "ssdp:alive"))
+    }
+    return nil
+}
+
+
+// Multicasts a NOTIFY on behalf of ads. Must hold interactionLock.
+func (s *Ssdp) sendNotify(ads *AdvertisableServer, heads map[string]string) {
+    write, err := s.notifyMessage(ads, heads)
+    if err != nil {
+        s.logger.Warn("Error sending advertisement", logSt, heads["NT"], logUsn, heads["USN"], "error", err)
+        return
+    }
+    s.writeChannel <- write
+    s.repeatNotify(ads, heads, write)
+}
+
+func (s *Ssdp) notifyMessage(ads *AdvertisableServer, heads map[string]string) (writeMessage, error) {
+    msg := createSsdpHeader(
+            "NOTIFY",
+            heads,
+            false,
+        )
+
+    to, err := net.ResolveUDPAddr("udp4", "239.255.255.250:1900")
+    if err != nil {
+        return writeMessage{}, err
+    }
+    return writeMessage{message: msg, to: to, sent: s.recordSend(ads)}, nil
+}
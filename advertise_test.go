@@ -0,0 +1,218 @@
+package gossdp
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "log/slog"
+    "net/http"
+    "slices"
+    "sort"
+    "strconv"
+    "testing"
+    "time"
+)
+
+
+const testDeviceUuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+
+func testServer() AdvertisableServer {
+    return AdvertisableServer{
+        ServiceType         : "urn:schemas-upnp-org:device:MediaServer:1",
+        DeviceUuid          : testDeviceUuid,
+        Location            : "http://192.168.1.20:8080/description.xml",
+        MaxAge              : 1800,
+    }
+}
+
+// An Ssdp that queues what it sends rather than sending it. Each NOTIFY goes out once.
+func testSsdp() *Ssdp {
+    s := newSsdp(nil, slog.Default())
+    s.isRunning = true
+    s.SetAdvertiseSchedule(AdvertiseSchedule{Repeat: 1})
+    return s
+}
+
+func parseNotifies(t *testing.T, msgs []writeMessage) []*http.Request {
+    var reqs []*http.Request
+    for _, msg := range msgs {
+        req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(msg.message)))
+        if err != nil {
+            t.Fatalf("%q: %v", msg.message, err)
+        }
+        if req.Method != "NOTIFY" || msg.to.String() != "239.255.255.250:1900" {
+            t.Errorf("sent %s to %v", req.Method, msg.to)
+        }
+        reqs = append(reqs, req)
+    }
+    return reqs
+}
+
+// Waits for a new device to come alive, then returns everything it sent.
+func waitAlive(t *testing.T, s *Ssdp) []*http.Request {
+    var sent []writeMessage
+    deadline := time.After(5 * time.Second)
+    for {
+        select {
+        case msg := <- s.writeChannel:
+            sent = append(sent, msg)
+            if messageHeader(msg.message, "NTS") != "ssdp:alive" {
+                continue
+            }
+            // the other ssdp:alive are queued under the same lock
+            s.interactionLock.Lock()
+            s.interactionLock.Unlock()
+            return parseNotifies(t, append(sent, queued(s)...))
+        case <- deadline:
+            t.Fatal("the device never came alive")
+            return nil
+        }
+    }
+}
+
+// The NT of each NOTIFY with the given NTS, sorted.
+func notifyTargets(reqs []*http.Request, nts string) []string {
+    var nt []string
+    for _, req := range reqs {
+        if req.Header.Get("NTS") == nts {
+            nt = append(nt, req.Header.Get("NT"))
+        }
+    }
+    sort.Strings(nt)
+    return nt
+}
+
+func TestUpdateServerLocation(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    bootId := s.devices[testDeviceUuid].bootId
+
+    const moved = "http://192.168.1.21:8080/description.xml"
+    err := s.UpdateServer(testDeviceUuid, func (ads *AdvertisableServer) {
+        ads.Location = moved
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    sent := parseNotifies(t, queued(s))
+    usns := []string{"upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:1", "uuid:" + testDeviceUuid}
+    if got := notifyTargets(sent, "ssdp:update"); !slices.Equal(got, usns) {
+        t.Errorf("ssdp:update for %v, want %v", got, usns)
+    }
+    if got := notifyTargets(sent, "ssdp:alive"); !slices.Equal(got, usns) {
+        t.Errorf("ssdp:alive for %v, want %v", got, usns)
+    }
+    for i, req := range sent {
+        h := req.Header
+        if h.Get("LOCATION") != moved || h.Get("CONFIGID.UPNP.ORG") != "1" {
+            t.Errorf("%s %s: LOCATION %s, CONFIGID %s", h.Get("NTS"), h.Get("NT"), h.Get("LOCATION"), h.Get("CONFIGID.UPNP.ORG"))
+        }
+        switch h.Get("NTS") {
+        case "ssdp:update":
+            if i >= len(usns) {
+                t.Errorf("ssdp:update for %s after ssdp:alive", h.Get("NT"))
+            }
+            if h.Get("BOOTID.UPNP.ORG") != strconv.Itoa(bootId) || h.Get("NEXTBOOTID.UPNP.ORG") != strconv.Itoa(bootId + 1) {
+                t.Errorf("ssdp:update for %s: BOOTID %s, NEXTBOOTID %s, want %d and %d", h.Get("NT"),
+                    h.Get("BOOTID.UPNP.ORG"), h.Get("NEXTBOOTID.UPNP.ORG"), bootId, bootId + 1)
+            }
+        case "ssdp:alive":
+            if h.Get("BOOTID.UPNP.ORG") != strconv.Itoa(bootId + 1) {
+                t.Errorf("ssdp:alive for %s: BOOTID %s, want %d", h.Get("NT"), h.Get("BOOTID.UPNP.ORG"), bootId + 1)
+            }
+        default:
+            t.Errorf("sent %s for %s", h.Get("NTS"), h.Get("NT"))
+        }
+    }
+}
+
+func TestUpdateServerServiceType(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    bootId := s.devices[testDeviceUuid].bootId
+
+    err := s.UpdateServer(testDeviceUuid, func (ads *AdvertisableServer) {
+        ads.ServiceType = "urn:schemas-upnp-org:device:MediaServer:2"
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    sent := parseNotifies(t, queued(s))
+    // the old USN says goodbye, without moving to a new BOOTID
+    if got := notifyTargets(sent, "ssdp:byebye"); !slices.Equal(got, []string{"urn:schemas-upnp-org:device:MediaServer:1"}) {
+        t.Errorf("ssdp:byebye for %v", got)
+    }
+    if got := notifyTargets(sent, "ssdp:update"); len(got) != 0 {
+        t.Errorf("ssdp:update for %v, want none", got)
+    }
+    want := []string{"upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:2", "uuid:" + testDeviceUuid}
+    if got := notifyTargets(sent, "ssdp:alive"); !slices.Equal(got, want) {
+        t.Errorf("ssdp:alive for %v, want %v", got, want)
+    }
+    if s.devices[testDeviceUuid].bootId != bootId {
+        t.Errorf("BOOTID changed")
+    }
+
+    // an update that breaks a header changes nothing
+    err = s.UpdateServer(testDeviceUuid, func (ads *AdvertisableServer) {
+        ads.Location += "\r\nX-Injected: 1"
+    })
+    if !errors.Is(err, ErrInvalidHeader) {
+        t.Errorf("got %v, want ErrInvalidHeader", err)
+    }
+    if sent := queued(s); len(sent) != 0 || s.devices[testDeviceUuid].services[0].ConfigId != 1 {
+        t.Errorf("a failed update sent %d messages", len(sent))
+    }
+    if err := s.UpdateServer("3fac1234-31f8-11b4-a222-08002b34c003", func (*AdvertisableServer) {}); err == nil {
+        t.Error("updated a device that isn't advertised")
+    }
+}
+
+func TestRemoveServer(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    // announced at once, as the device already is
+    directory := testServer()
+    directory.ServiceType = "urn:schemas-upnp-org:service:ContentDirectory:1"
+    if err := s.AdvertiseServer(directory); err != nil {
+        t.Fatal(err)
+    }
+    if got := notifyTargets(parseNotifies(t, queued(s)), "ssdp:alive"); !slices.Equal(got, []string{directory.ServiceType}) {
+        t.Errorf("a new service sent ssdp:alive for %v", got)
+    }
+
+    // the device level USNs stay with the other service
+    s.RemoveService(testDeviceUuid, directory.ServiceType)
+    sent := parseNotifies(t, queued(s))
+    if got := notifyTargets(sent, "ssdp:byebye"); !slices.Equal(got, []string{directory.ServiceType}) || len(sent) != 1 {
+        t.Errorf("RemoveService sent ssdp:byebye for %v, %d messages in all", got, len(sent))
+    }
+    if h := sent[0].Header; h.Get("USN") != "uuid:" + testDeviceUuid + "::" + directory.ServiceType || h.Get("LOCATION") != "" {
+        t.Errorf("ssdp:byebye USN %s, LOCATION %s", h.Get("USN"), h.Get("LOCATION"))
+    }
+
+    s.RemoveServer(testDeviceUuid)
+    sent = parseNotifies(t, queued(s))
+    want := []string{"upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:1", "uuid:" + testDeviceUuid}
+    if got := notifyTargets(sent, "ssdp:byebye"); !slices.Equal(got, want) || len(sent) != len(want) {
+        t.Errorf("RemoveServer sent ssdp:byebye for %v, %d messages in all, want %v", got, len(sent), want)
+    }
+    if len(s.Servers()) != 0 {
+        t.Errorf("still advertising %+v", s.Servers())
+    }
+
+    // removing it again says nothing
+    s.RemoveServer(testDeviceUuid)
+    if sent := queued(s); len(sent) != 0 {
+        t.Errorf("removing twice sent %d messages", len(sent))
+    }
+}
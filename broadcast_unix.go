@@ -34,9 +34,12 @@
 package gossdp
 
 import (
+    "context"
     "golang.org/x/net/ipv4"
     "net"
     "errors"
+    "syscall"
+    "time"
 )
 
 
@@ -44,6 +47,8 @@ type theSocket struct {
     rawSocket               net.PacketConn
     socket                  *ipv4.PacketConn
     readBytes               []byte
+    // interface names by index, for captures
+    interfaceNames          map[int]string
 }
 
 
@@ -52,19 +57,43 @@ func (ts theSocket) IsValid() bool {
 }
 
 func (s *Ssdp) createSocket() error {
-    group := net.IPv4(239, 255, 255, 250)
     interfaces, err := net.Interfaces()
     if err != nil {
-        s.logger.Errorf("net.Interfaces error", err)
+        s.logger.Error("net.Interfaces error", "error", err)
         return err
     }
-    con, err := net.ListenPacket("udp4", "0.0.0.0:1900")
+    return s.createSocketOn(interfaces)
+}
+
+func sharePort(network, address string, c syscall.RawConn) error {
+    var sockErr error
+    err := c.Control(func (fd uintptr) {
+        sockErr = setSharePort(int(fd))
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
+
+// Binds :1900, joining the multicast group on the given interfaces.
+func (s *Ssdp) createSocketOn(interfaces []net.Interface) error {
+    group := net.IPv4(239, 255, 255, 250)
+    var lc net.ListenConfig
+    if s.sharePort {
+        lc.Control = sharePort
+    }
+    con, err := lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:1900")
     if err != nil {
-        s.logger.Errorf("net.ListenPacket error: %v", err)
+        s.logger.Error("net.ListenPacket error", "error", err)
         return err
     }
     p := ipv4.NewPacketConn(con)
     p.SetMulticastLoopback(true)
+    // tells us which interface and address each packet arrived on
+    if err := p.SetControlMessage(ipv4.FlagInterface | ipv4.FlagDst, true); err != nil {
+        s.logger.Debug("Can't read packet interfaces", "error", err)
+    }
     didFindInterface := false
     for i, v := range interfaces {
         ef, err := v.Addrs()
@@ -85,17 +114,19 @@ func (s *Ssdp) createSocket() error {
         }
         err = p.JoinGroup(&v, &net.UDPAddr{IP: group})
         if err != nil {
-            s.logger.Warnf("join group %d %v", i, err)
+            s.logger.Warn("join group", logInterface, v.Name, "index", i, "error", err)
             continue
         }
         didFindInterface = true
     }
     if !didFindInterface {
+        con.Close()
         return errors.New("Unable to find a compatible network interface!")
     }
     s.socket.socket = p
     s.socket.rawSocket = con
     s.socket.readBytes = make([]byte, 2048)
+    s.socket.interfaceNames = make(map[int]string)
     return nil
 }
 
@@ -105,19 +136,41 @@ func (s * Ssdp) closeSocket() {
     s.socket.rawSocket = nil
 }
 
-func (s * Ssdp) read() ([]byte, string, error) {
-    n, src, err := s.socket.rawSocket.ReadFrom(s.socket.readBytes)
+func (s * Ssdp) read() (Packet, error) {
+    n, cm, src, err := s.socket.socket.ReadFrom(s.socket.readBytes)
     if err != nil {
-        return nil, "", err
+        return Packet{}, err
     }
-    if n > 0 {
-        //s.logger.Infof("Message: %s", string(readBytes[0:n]))
-        return s.socket.readBytes[0:n], src.String(), nil
+    p := Packet{Time: time.Now(), Data: s.socket.readBytes[0:n]}
+    p.Source, _ = src.(*net.UDPAddr)
+    if cm != nil {
+        p.Destination = &net.UDPAddr{IP: cm.Dst, Port: ssdpPort}
+        p.Interface = s.socket.interfaceName(cm.IfIndex)
     }
-    return nil, "", nil
+    return p, nil
+}
+
+func (ts theSocket) interfaceName(index int) string {
+    if index == 0 {
+        return ""
+    }
+    if name, ok := ts.interfaceNames[index]; ok {
+        return name
+    }
+    name := ""
+    if iface, err := net.InterfaceByIndex(index); err == nil {
+        name = iface.Name
+    }
+    ts.interfaceNames[index] = name
+    return name
 }
 
 func (s *Ssdp) write(msg writeMessage) error {
+    if msg.ifIndex != 0 {
+        // out of one interface, rather than the one the routing table picks
+        _, err := s.socket.socket.WriteTo(msg.message, &ipv4.ControlMessage{IfIndex: msg.ifIndex}, msg.to)
+        return err
+    }
     _, err := s.socket.rawSocket.WriteTo(msg.message, msg.to)
     return err
 }
@@ -33,8 +33,8 @@ package gossdp
 
 import (
     "encoding/binary"
-    "fmt"
     "syscall"
+    "time"
     "unsafe"
     "net"
 )
@@ -125,7 +125,7 @@ func (s *Ssdp) closeSocket() {
 }
 
 
-func (s *Ssdp) read() ([]byte, string, error) {
+func (s *Ssdp) read() (Packet, error) {
     bufs := syscall.WSABuf{
         Len: 2048,
         Buf: &s.socket.readBytes[0],
@@ -136,7 +136,7 @@ func (s *Ssdp) read() ([]byte, string, error) {
     fromSize := int32(unsafe.Sizeof(asIp4))
     err := syscall.WSARecvFrom(s.socket.socket, &bufs, 1, &n, &flags, fromAny, &fromSize, nil, nil)
     if err != nil {
-        return nil, "", err
+        return Packet{}, err
     }
     if n > 0 {
         // need to convert the port bytes ordering
@@ -144,11 +144,10 @@ func (s *Ssdp) read() ([]byte, string, error) {
         binary.BigEndian.PutUint16(portBytes, asIp4.Port)
         port := binary.LittleEndian.Uint16(portBytes)
         // set the address
-        src := fmt.Sprintf("%d.%d.%d.%d:%d", asIp4.Addr[0], asIp4.Addr[1], asIp4.Addr[2], asIp4.Addr[3], port)
-        //s.logger.Infof("Message: %s", string(readBytes[0:n]))
-        return s.socket.readBytes[0:n], src, nil
+        src := &net.UDPAddr{IP: net.IPv4(asIp4.Addr[0], asIp4.Addr[1], asIp4.Addr[2], asIp4.Addr[3]), Port: int(port)}
+        return Packet{Time: time.Now(), Source: src, Data: s.socket.readBytes[0:n]}, nil
     }
-    return nil, "", nil
+    return Packet{}, nil
 }
 
 
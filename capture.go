@@ -0,0 +1,117 @@
+package gossdp
+
+import (
+    "io"
+    "log/slog"
+    "net"
+    "sync/atomic"
+    "time"
+)
+
+
+// A datagram we read or wrote.
+type Packet struct {
+    Time                time.Time
+    // True for what we wrote, false for what we read
+    Outbound            bool
+    // The name of the network interface it arrived on, when the platform tells us.
+    //  Empty for outbound packets, as multicasts leave on every interface
+    Interface           string
+    Source              *net.UDPAddr
+    Destination         *net.UDPAddr
+    // The SSDP message. Only valid during CapturePacket
+    Data                []byte
+}
+
+// Receives a copy of every datagram read or written. PcapngWriter is one.
+// Called from the reader and writer goroutines, so it must be safe for concurrent use.
+type PacketCapture interface {
+    CapturePacket(p Packet)
+}
+
+// Tees every datagram into c, eg. a PcapngWriter. Must be called before Run. nil stops capturing.
+func (s *Ssdp) SetCapture(c PacketCapture) {
+    s.capture = c
+}
+
+// Like Ssdp.SetCapture.
+func (c *ClientSsdp) SetCapture(pc PacketCapture) {
+    c.capture = pc
+}
+
+// Creates a server that reads a capture instead of the network. See NewCaptureReader.
+// Run feeds every inbound datagram to or from port 1900 through the listener, subscribers
+// and Events as if it had just arrived, as fast as it can, then shuts down and returns nil.
+// Nothing is sent: what would have been written only goes to the capture set by SetCapture.
+func NewSsdpReplay(l SsdpListener, capture io.Reader, lg *slog.Logger) (*Ssdp, error) {
+    r, err := NewCaptureReader(capture)
+    if err != nil {
+        return nil, err
+    }
+    s := newSsdp(l, lg)
+    s.replay = &replaySource{reader: r}
+    s.isRunning = true
+    return s, nil
+}
+
+// Feeds a capture to Run in place of the socket.
+type replaySource struct {
+    reader              *CaptureReader
+    closed              atomic.Bool
+}
+
+func (r *replaySource) next() (Packet, error) {
+    for {
+        if r.closed.Load() {
+            return Packet{}, io.EOF
+        }
+        p, err := r.reader.Next()
+        if err != nil {
+            return Packet{}, err
+        }
+        if p.Outbound {
+            continue
+        }
+        if p.Source.Port == ssdpPort || p.Destination.Port == ssdpPort {
+            return p, nil
+        }
+    }
+}
+
+func (r *replaySource) close() {
+    r.closed.Store(true)
+}
+
+// Reads the next datagram from the socket or the replay, capturing it.
+func (s *Ssdp) readPacket() (Packet, error) {
+    var p Packet
+    var err error
+    if s.replay != nil {
+        p, err = s.replay.next()
+    } else {
+        p, err = s.read()
+    }
+    if err == nil && s.capture != nil && len(p.Data) > 0 {
+        s.capture.CapturePacket(p)
+    }
+    return p, err
+}
+
+// Writes to the socket, or nowhere when replaying, capturing what went out.
+func (s *Ssdp) writePacket(msg writeMessage) error {
+    var err error
+    if s.replay == nil {
+        err = s.write(msg)
+    }
+    if err == nil && s.capture != nil {
+        s.capture.CapturePacket(Packet{
+            Time                : time.Now(),
+            Outbound            : true,
+            // bound to 0.0.0.0:1900 on every platform
+            Source              : &net.UDPAddr{IP: net.IPv4zero, Port: ssdpPort},
+            Destination         : msg.to,
+            Data                : msg.message,
+        })
+    }
+    return err
+}
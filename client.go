@@ -1,9 +1,14 @@
 package gossdp
 
 import (
+    "context"
+    "log/slog"
     "net"
+    "strconv"
     "sync"
     "strings"
+    "time"
+    "golang.org/x/net/ipv4"
 )
 
 
@@ -22,7 +27,11 @@ type ClientSsdp struct {
     exitReadWaitGroup       sync.WaitGroup
     interactionLock         sync.Mutex
     isRunning               bool
-    logger                  LoggerInterface
+    events                  eventStream
+    subscribers             subscriberList
+    logger                  *slog.Logger
+    metrics                 Metrics
+    capture                 PacketCapture
 }
 
 
@@ -37,14 +46,22 @@ type ClientSsdp struct {
 //      A future improvment would be to bind both to the random port
 //      and :1900 so we can listen for broadcasts, and get replies.
 func NewSsdpClient(l ClientListener) (*ClientSsdp, error) {
-    return NewSsdpClientWithLogger(l, DefaultLogger{})
+    return NewSsdpClientWithSlog(l, slog.Default())
 }
 
+// Creates a new client that logs to lg from Info up.
+// For packet tracing, use NewSsdpClientWithSlog with NewLoggerHandler(lg, LevelTrace).
 func NewSsdpClientWithLogger(l ClientListener, lg LoggerInterface) (*ClientSsdp, error) {
+    return NewSsdpClientWithSlog(l, slog.New(NewLoggerHandler(lg, slog.LevelInfo)))
+}
+
+// Creates a new client that logs to lg.
+func NewSsdpClientWithSlog(l ClientListener, lg *slog.Logger) (*ClientSsdp, error) {
     var c ClientSsdp
     c.listener = l
-    c.writeChannel = make(chan writeMessage)
+    c.writeChannel = make(chan writeMessage, writeQueueSize)
     c.logger = lg
+    c.metrics = noMetrics{}
     if err := c.createSocket(); err != nil {
         return nil, err
     }
@@ -53,6 +70,11 @@ func NewSsdpClientWithLogger(l ClientListener, lg LoggerInterface) (*ClientSsdp,
     return &c, nil
 }
 
+// Sends searches out of iface, rather than the interface the system picks.
+func (c *ClientSsdp) SetInterface(iface *net.Interface) error {
+    return ipv4.NewPacketConn(c.socket).SetMulticastInterface(iface)
+}
+
 func (c *ClientSsdp) createSocket() error {
     addr, err := net.ResolveUDPAddr("udp4", "0.0.0.0:0")
     if err != nil {
@@ -65,43 +87,58 @@ func (c *ClientSsdp) createSocket() error {
     return nil
 }
 
+func (c *ClientSsdp) localAddr() *net.UDPAddr {
+    addr, _ := c.socket.LocalAddr().(*net.UDPAddr)
+    return addr
+}
+
 func (c *ClientSsdp) parseMessage(message, hostPort string) {
     if strings.HasPrefix(message, "HTTP") {
         respData := parseResponse(message, hostPort)
-        if respData != nil && c.listener != nil {
-            c.listener.Response(*respData)
+        if respData == nil {
+            c.metrics.ParseFailure()
+            return
         }
+        c.metrics.PacketIn("RESPONSE", "")
+        c.dispatch(Event{Type: EventResponse, Source: hostPort, Response: respData})
         return
     }
-    c.logger.Warnf("Unknown message. We only expect replies.")
+    c.logger.Warn("Unknown message. We only expect replies", logSource, hostPort)
+    c.metrics.ParseFailure()
     return
 }
 
-// Starts listening to packets on the network.
+// Starts listening to packets on the network. Blocks until Stop is called.
+//
+// Deprecated: use Run, which also reports why it stopped.
 func (c *ClientSsdp) Start() {
-    go c.socketWriter()
-    c.socketReader()
+    if err := c.Run(context.Background()); err != nil {
+        c.logger.Warn("Stopped running", "error", err)
+    }
 }
 
-func (c *ClientSsdp) socketReader() {
-    c.exitReadWaitGroup.Add(1)
-    defer c.exitReadWaitGroup.Add(-1)
+// Reads until the socket fails or is closed.
+func (c *ClientSsdp) socketReader() error {
+    defer c.exitReadWaitGroup.Done()
     readBytes := make([]byte, 2048)
     for {
         n, src, err := c.socket.ReadFrom(readBytes)
         if err != nil {
-            c.logger.Warnf("Error reading from socket: %v", err)
-            return
+            return err
         }
         if n > 0 {
+            trace(c.logger, "Received message", logSource, logAddr{src}, logDirection, "in", "bytes", n)
+            if c.capture != nil {
+                from, _ := src.(*net.UDPAddr)
+                c.capture.CapturePacket(Packet{Time: time.Now(), Source: from, Destination: c.localAddr(), Data: readBytes[0:n]})
+            }
             c.parseMessage(string(readBytes[0:n]), src.String())
         }
     }
 }
 
 func (c *ClientSsdp) socketWriter() {
-    c.exitWriteWaitGroup.Add(1)
-    defer c.exitWriteWaitGroup.Add(-1)
+    defer c.exitWriteWaitGroup.Done()
     for {
         msg, more := <- c.writeChannel
         if !more {
@@ -109,38 +146,53 @@ func (c *ClientSsdp) socketWriter() {
         }
         _, err := c.socket.WriteTo(msg.message, msg.to)
         if err != nil {
-            c.logger.Warnf("Error sending message. %v", err)
+            c.logger.Warn("Error sending message", logDestination, msg.to.String(), logDirection, "out", "error", err)
+            c.metrics.WriteError()
+        } else {
+            trace(c.logger, "Sent message", logDestination, logAddr{msg.to}, logDirection, "out", "bytes", len(msg.message))
+            countSent(c.metrics, msg)
+            if c.capture != nil {
+                c.capture.CapturePacket(Packet{Time: time.Now(), Outbound: true, Source: c.localAddr(), Destination: msg.to, Data: msg.message})
+            }
         }
     }
 }
 
 // Kills the client by closing the socket.
-// If any servers are being advertised they will NOTIFY a byebye
+// Gives up on unsent searches after 5 seconds. See Shutdown for more control.
+//
+// Deprecated: cancel the context given to Run, or call Shutdown.
 func (c *ClientSsdp) Stop() {
-    c.interactionLock.Lock()
-    c.isRunning = false
-    c.interactionLock.Unlock()
-
-    if c.socket != nil {
-        close(c.writeChannel)
-        c.exitWriteWaitGroup.Wait()
-        c.socket.Close()
-        c.exitReadWaitGroup.Wait()
-        c.socket = nil
+    ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+    defer cancel()
+    if err := c.Shutdown(ctx); err != nil {
+        c.logger.Warn("Error stopping", "error", err)
     }
-    c.logger.Tracef("Stop exiting")
 }
 
 
 // Sends out 1 M-SEARCH request for the specified target.
 func (c *ClientSsdp) ListenFor(searchTarget string) error {
+    return c.Search(searchTarget, 3)
+}
+
+// Multicasts 1 M-SEARCH for the target. Devices answer within mx seconds,
+// which must be between 1 and 120. UPnP 1.1 devices treat anything over 5 as 5.
+// A target with control characters returns ErrInvalidHeader.
+func (c *ClientSsdp) Search(searchTarget string, mx int) error {
+    if mx < 1 || mx > maxSearchWait {
+        return ErrSearchBadMx
+    }
+    if err := checkHeader("ST", searchTarget); err != nil {
+        return err
+    }
     msg := createSsdpHeader(
         "M-SEARCH",
         map[string]string{
             "HOST": "239.255.255.250:1900",
             "ST": searchTarget,
             "MAN": `"ssdp:discover"`,
-            "MX": "3",
+            "MX": strconv.Itoa(mx),
         },
         false,
     )
@@ -149,6 +201,38 @@ func (c *ClientSsdp) ListenFor(searchTarget string) error {
     if err != nil {
         return err
     }
+    c.queueSearch(msg, addr)
+    return nil
+}
+
+// Sends 1 M-SEARCH for the target straight to one device, as UPnP 1.1 allows.
+// host is its IP address, with port 1900 unless another is given.
+// The device answers at once, and the response arrives like any other.
+func (c *ClientSsdp) SearchUnicast(searchTarget string, host string) error {
+    if err := checkHeader("ST", searchTarget); err != nil {
+        return err
+    }
+    if _, _, err := net.SplitHostPort(host); err != nil {
+        host = net.JoinHostPort(host, strconv.Itoa(ssdpPort))
+    }
+    addr, err := net.ResolveUDPAddr("udp4", host)
+    if err != nil {
+        return err
+    }
+    msg := createSsdpHeader(
+        "M-SEARCH",
+        map[string]string{
+            "HOST": addr.String(),
+            "ST": searchTarget,
+            "MAN": `"ssdp:discover"`,
+        },
+        false,
+    )
+    c.queueSearch(msg, addr)
+    return nil
+}
+
+func (c *ClientSsdp) queueSearch(msg []byte, to *net.UDPAddr) {
     // run in a goroutine, because Start may not have been called yet
     // and thus s.writeChannel will block!
     go func() {
@@ -157,8 +241,6 @@ func (c *ClientSsdp) ListenFor(searchTarget string) error {
         if !c.isRunning {
             return
         }
-        c.writeChannel <- writeMessage{msg, addr, false}
+        c.writeChannel <- writeMessage{message: msg, to: to}
     }()
-
-    return err
 }
\ No newline at end of file
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func runAdvertise(args []string) error {
+    var o options
+    fs := newFlagSet("advertise", &o)
+    var serviceTypes stringsFlag
+    fs.Var(&serviceTypes, "st", "the service type to advertise. May be repeated (default urn:schemas-upnp-org:device:Basic:1)")
+    uuid := fs.String("uuid", "", "the device UUID. Made from the MAC address and service type if not given")
+    uuidFile := fs.String("uuid-file", "", "keep the generated device UUID in this file, so it is the same next time")
+    location := fs.String("location", "", "the URL of the device description (required)")
+    maxAge := fs.Int("max-age", 1800, "seconds the advertisement is valid for")
+    if _, err := parseArgs(fs, args, 0); err != nil {
+        return err
+    }
+    if *location == "" {
+        fmt.Fprintln(fs.Output(), "--location is required")
+        fs.Usage()
+        return errUsage
+    }
+    if *maxAge < 1 {
+        return errors.New("--max-age must be positive")
+    }
+    if len(serviceTypes) == 0 {
+        serviceTypes = stringsFlag{"urn:schemas-upnp-org:device:Basic:1"}
+    }
+    if *uuid == "" {
+        var err error
+        if *uuidFile != "" {
+            *uuid, err = gossdp.PersistentUuid(*uuidFile, gossdp.NewUuid)
+        } else {
+            *uuid, err = gossdp.LocalDeviceUuid(serviceTypes[0])
+        }
+        if err != nil {
+            return err
+        }
+    }
+    if !gossdp.ValidUuid(*uuid) {
+        return gossdp.ErrInvalidDeviceUuid
+    }
+
+    s, err := gossdp.NewSsdpWithSlog(nil, o.logger())
+    if err != nil {
+        return err
+    }
+    // show who is looking for us
+    out := &output{w: os.Stdout, json: o.json, stream: true}
+    s.Subscribe(gossdp.Filter{Types: []gossdp.EventType{gossdp.EventSearch}}, func (ev gossdp.Event) {
+        out.add(eventRecord(ev))
+    })
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    done := make(chan error, 1)
+    go func () {
+        done <- s.Run(ctx)
+    }()
+    for _, st := range serviceTypes {
+        err := s.AdvertiseServer(gossdp.AdvertisableServer{
+            ServiceType     : st,
+            DeviceUuid      : *uuid,
+            Location        : *location,
+            MaxAge          : *maxAge,
+        })
+        if err != nil {
+            cancel()
+            <- done
+            return err
+        }
+    }
+    fmt.Fprintf(os.Stderr, "Advertising uuid:%s at %s. Press Ctrl-C to stop\n", *uuid, *location)
+    return <- done
+}
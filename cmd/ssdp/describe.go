@@ -0,0 +1,71 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func runDescribe(args []string) error {
+    var o options
+    fs := newFlagSet("describe", &o)
+    timeout := fs.Duration("timeout", 10 * time.Second, "how long to wait for the description")
+    raw := fs.Bool("xml", false, "print the description XML as it was fetched")
+    positional, err := parseArgs(fs, args, 1)
+    if err != nil {
+        return err
+    }
+    if len(positional) == 0 {
+        fmt.Fprintln(fs.Output(), "Missing the location to describe")
+        fs.Usage()
+        return errUsage
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+    desc, err := gossdp.FetchDescription(ctx, positional[0])
+    if err != nil {
+        return err
+    }
+
+    switch {
+    case *raw:
+        os.Stdout.WriteString(printable(string(desc.Raw), true))
+    case o.json:
+        return json.NewEncoder(os.Stdout).Encode(desc)
+    default:
+        printDescription(desc)
+    }
+    return nil
+}
+
+func printDescription(desc *gossdp.DeviceDescription) {
+    tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    // every field is as the device sent it
+    p := func (s string) string { return printable(s, false) }
+    fmt.Fprintf(tw, "UPnP %d.%d\n", desc.SpecVersion.Major, desc.SpecVersion.Minor)
+    for _, d := range desc.Device.All() {
+        fmt.Fprintln(tw)
+        fmt.Fprintf(tw, "Device\t%s\n", p(d.FriendlyName))
+        fmt.Fprintf(tw, "  Type\t%s\n", p(d.DeviceType))
+        fmt.Fprintf(tw, "  UDN\t%s\n", p(d.UDN))
+        fmt.Fprintf(tw, "  Manufacturer\t%s\n", p(d.Manufacturer))
+        fmt.Fprintf(tw, "  Model\t%s %s\n", p(d.ModelName), p(d.ModelNumber))
+        if d.SerialNumber != "" {
+            fmt.Fprintf(tw, "  Serial\t%s\n", p(d.SerialNumber))
+        }
+        if d.PresentationURL != "" {
+            fmt.Fprintf(tw, "  Presentation\t%s\n", p(d.PresentationURL))
+        }
+        for _, s := range d.Services {
+            fmt.Fprintf(tw, "  Service\t%s\t%s\n", p(s.ServiceType), p(s.ControlURL))
+        }
+    }
+    tw.Flush()
+}
@@ -0,0 +1,158 @@
+/*
+Ssdp searches for, watches and advertises SSDP devices.
+
+Usage:
+
+    ssdp search [flags] [st]        multicast an M-SEARCH and list the responses
+    ssdp monitor [flags]            print NOTIFY messages as they arrive
+    ssdp advertise [flags]          advertise a device until interrupted
+    ssdp describe [flags] location  fetch and print a device description
+    ssdp top [flags]                watch devices come and go
+    ssdp relay [flags]              forward SSDP between network interfaces
+    ssdp tunnel [flags]             bridge SSDP with another site over TCP or UDP
+
+Output is a table, or JSON lines with --json. -v logs what is sent and received.
+Run "ssdp <command> -h" for the flags of a command.
+*/
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "log/slog"
+    "os"
+    "strings"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+type command struct {
+    name                string
+    args                string
+    summary             string
+    run                 func(args []string) error
+}
+
+var commands []command
+
+func init() {
+    commands = []command{
+        {"search", "[flags] [st]", "multicast an M-SEARCH and list the responses", runSearch},
+        {"monitor", "[flags]", "print NOTIFY messages as they arrive", runMonitor},
+        {"advertise", "[flags]", "advertise a device until interrupted", runAdvertise},
+        {"describe", "[flags] location", "fetch and print a device description", runDescribe},
+        {"top", "[flags]", "watch devices come and go", runTop},
+        {"relay", "[flags]", "forward SSDP between network interfaces", runRelay},
+        {"tunnel", "[flags]", "bridge SSDP with another site over TCP or UDP", runTunnel},
+    }
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "Usage: ssdp <command> [flags] [args]")
+    fmt.Fprintln(os.Stderr)
+    for _, c := range commands {
+        fmt.Fprintf(os.Stderr, "    %-10s %s\n", c.name, c.summary)
+    }
+    fmt.Fprintln(os.Stderr)
+    fmt.Fprintln(os.Stderr, `Run "ssdp <command> -h" for the flags of a command.`)
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+    name := os.Args[1]
+    if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+        usage()
+        return
+    }
+    for _, c := range commands {
+        if c.name != name {
+            continue
+        }
+        err := c.run(os.Args[2:])
+        if errors.Is(err, flag.ErrHelp) {
+            return
+        }
+        if errors.Is(err, errUsage) {
+            os.Exit(2)
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "ssdp %s: %v\n", name, err)
+            os.Exit(1)
+        }
+        return
+    }
+    fmt.Fprintf(os.Stderr, "ssdp: unknown command %q\n", name)
+    usage()
+    os.Exit(2)
+}
+
+// Returned once the command printed how it should be used.
+var errUsage = errors.New("Bad usage")
+
+// The flags every command has.
+type options struct {
+    json                bool
+    verbose             bool
+}
+
+func newFlagSet(c string, o *options) *flag.FlagSet {
+    fs := flag.NewFlagSet(c, flag.ContinueOnError)
+    fs.BoolVar(&o.json, "json", false, "print JSON lines instead of a table")
+    fs.BoolVar(&o.verbose, "v", false, "log every packet sent and received")
+    fs.Usage = func () {
+        for _, cmd := range commands {
+            if cmd.name == c {
+                fmt.Fprintf(fs.Output(), "Usage: ssdp %s %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, strings.ToUpper(cmd.summary[:1]) + cmd.summary[1:])
+            }
+        }
+        fs.PrintDefaults()
+    }
+    return fs
+}
+
+// Logs warnings to stderr, or everything with -v.
+func (o options) logger() *slog.Logger {
+    level := slog.LevelWarn
+    if o.verbose {
+        level = gossdp.LevelTrace
+    }
+    return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// Parses flags that may come before or after the positional arguments, which it returns.
+func parseArgs(fs *flag.FlagSet, args []string, maxPositional int) ([]string, error) {
+    var positional []string
+    for {
+        if err := fs.Parse(args); err != nil {
+            return nil, err
+        }
+        if fs.NArg() == 0 {
+            break
+        }
+        positional = append(positional, fs.Arg(0))
+        args = fs.Args()[1:]
+    }
+    if len(positional) > maxPositional {
+        fmt.Fprintf(fs.Output(), "Too many arguments: %s\n", strings.Join(positional, " "))
+        fs.Usage()
+        return nil, errUsage
+    }
+    return positional, nil
+}
+
+// A flag that may be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+    return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+    *f = append(*f, v)
+    return nil
+}
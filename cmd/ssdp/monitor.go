@@ -0,0 +1,39 @@
+package main
+
+import (
+    "context"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func runMonitor(args []string) error {
+    var o options
+    fs := newFlagSet("monitor", &o)
+    var targets stringsFlag
+    fs.Var(&targets, "st", "only show this NT or ST. May be repeated")
+    searches := fs.Bool("searches", false, "also show M-SEARCH requests from others")
+    if _, err := parseArgs(fs, args, 0); err != nil {
+        return err
+    }
+
+    s, err := gossdp.NewSsdpWithSlog(nil, o.logger())
+    if err != nil {
+        return err
+    }
+    filter := gossdp.Filter{SearchTargets: targets}
+    if *searches {
+        filter.Types = []gossdp.EventType{gossdp.EventAlive, gossdp.EventBye, gossdp.EventUpdate, gossdp.EventSearch}
+    }
+    out := &output{w: os.Stdout, json: o.json, stream: true}
+    s.Subscribe(filter, func (ev gossdp.Event) {
+        out.add(eventRecord(ev))
+    })
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    return s.Run(ctx)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+    "unicode/utf8"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// What --st lets through: messages for one of targets. Searches for everything pass
+// too, and only the matching responses come back.
+func targetFilter(targets []string) gossdp.Filter {
+    return gossdp.Filter{
+        Match               : func (ev gossdp.Event) bool {
+            if ev.Type == gossdp.EventSearch && ev.Search.SearchType.Kind == gossdp.TargetAll {
+                return true
+            }
+            for _, st := range targets {
+                if ev.SearchType().String() == st {
+                    return true
+                }
+            }
+            return false
+        },
+    }
+}
+
+// One line of output.
+type record struct {
+    Time                time.Time       `json:"time"`
+    Type                string          `json:"type"`
+    Source              string          `json:"source"`
+    Interface           string          `json:"interface,omitempty"`
+    SearchType          string          `json:"st,omitempty"`
+    Usn                 string          `json:"usn,omitempty"`
+    Location            string          `json:"location,omitempty"`
+    Server              string          `json:"server,omitempty"`
+    MaxAge              int             `json:"max_age,omitempty"`
+    BootId              int             `json:"boot_id,omitempty"`
+    ConfigId            int             `json:"config_id,omitempty"`
+    NextBootId          int             `json:"next_boot_id,omitempty"`
+    // for searches
+    MaxWait             int             `json:"mx,omitempty"`
+    UserAgent           string          `json:"user_agent,omitempty"`
+    Rejected            string          `json:"rejected,omitempty"`
+}
+
+func eventRecord(ev gossdp.Event) record {
+    r := record{
+        Time                : ev.Time,
+        Type                : ev.Type.String(),
+        Source              : ev.Source,
+        Interface           : ev.Interface,
+        SearchType          : ev.SearchType().String(),
+        Location            : ev.Location(),
+        Server              : ev.Server(),
+        MaxAge              : ev.MaxAge(),
+    }
+    if usn := ev.Usn(); usn.Uuid != "" {
+        r.Usn = usn.String()
+    }
+    switch ev.Type {
+    case gossdp.EventAlive:
+        r.BootId, r.ConfigId = ev.Alive.BootId, ev.Alive.ConfigId
+    case gossdp.EventBye:
+        r.BootId, r.ConfigId = ev.Bye.BootId, ev.Bye.ConfigId
+    case gossdp.EventUpdate:
+        r.BootId, r.ConfigId, r.NextBootId = ev.Update.BootId, ev.Update.ConfigId, ev.Update.NextBootId
+    case gossdp.EventResponse:
+        r.BootId, r.ConfigId = ev.Response.BootId, ev.Response.ConfigId
+    case gossdp.EventSearch:
+        r.MaxWait = ev.Search.MaxWait
+        r.UserAgent = ev.Search.UserAgent
+        if ev.Search.Rejected != nil {
+            r.Rejected = ev.Search.Rejected.Error()
+        }
+    }
+    // missing headers are -1
+    for _, v := range []*int{&r.MaxAge, &r.BootId, &r.ConfigId, &r.NextBootId} {
+        if *v < 0 {
+            *v = 0
+        }
+    }
+    return r
+}
+
+// Prints records as JSON lines, or as a table.
+// A streaming table prints each row as it comes, otherwise rows wait for flush so they line up.
+type output struct {
+    w                   io.Writer
+    json                bool
+    stream              bool
+    rows                []record
+    wroteHeader         bool
+}
+
+func (o *output) add(r record) {
+    switch {
+    case o.json:
+        json.NewEncoder(o.w).Encode(r)
+    case o.stream:
+        if !o.wroteHeader {
+            fmt.Fprintf(o.w, "%-8s  %-8s  %-21s  %s\n", "TIME", "TYPE", "SOURCE", "USN / LOCATION")
+            o.wroteHeader = true
+        }
+        detail := r.Usn
+        if r.Type == "search" {
+            detail = "ST " + r.SearchType
+            if r.Rejected != "" {
+                detail += " (" + r.Rejected + ")"
+            }
+        }
+        if r.Location != "" {
+            detail += "  " + r.Location
+        }
+        fmt.Fprintf(o.w, "%-8s  %-8s  %-21s  %s\n", r.Time.Format("15:04:05"), r.Type, r.Source, detail)
+    default:
+        o.rows = append(o.rows, r)
+    }
+}
+
+func (o *output) flush() {
+    if o.json || o.stream {
+        return
+    }
+    tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(tw, "SOURCE\tST\tUSN\tLOCATION\tSERVER\tMAX-AGE")
+    for _, r := range o.rows {
+        fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Source, r.SearchType, r.Usn, r.Location, r.Server, strconv.Itoa(r.MaxAge))
+    }
+    tw.Flush()
+    o.rows = nil
+}
+
+// Escapes the C0 and C1 control characters in text from the network, so a device can't
+// move the cursor, retitle the terminal or hide what was printed before it.
+// When lines is true, \n and \t are kept and \r\n becomes \n, for multi-line text like XML.
+func printable(s string, lines bool) string {
+    var b strings.Builder
+    for len(s) > 0 {
+        r, size := utf8.DecodeRuneInString(s)
+        switch {
+        case r == utf8.RuneError && size == 1:
+            // a lone byte, which a terminal not in UTF-8 may take as a C1 control
+            fmt.Fprintf(&b, `\x%02x`, s[0])
+        case lines && (r == '\n' || r == '\t'):
+            b.WriteRune(r)
+        case lines && r == '\r' && strings.HasPrefix(s[1:], "\n"):
+            // the \n is written next
+        case r < 0x20 || (r >= 0x7f && r < 0xa0):
+            fmt.Fprintf(&b, `\x%02x`, r)
+        default:
+            b.WriteString(s[:size])
+        }
+        s = s[size:]
+    }
+    return b.String()
+}
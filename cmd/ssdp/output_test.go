@@ -0,0 +1,54 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func TestPrintable(t *testing.T) {
+    tests := []struct {
+        s           string
+        lines       bool
+        want        string
+    }{
+        {"Linux/1 UPnP/1.1 test/1", false, "Linux/1 UPnP/1.1 test/1"},
+        {"Café über", false, "Café über"},
+        {"\x1b]0;pwned\x07", false, `\x1b]0;pwned\x07`},
+        {"a\r\nb\tc", false, `a\x0d\x0ab\x09c`},
+        {"del\x7f", false, `del\x7f`},
+        {"csi\u009b2J", false, `csi\x9b2J`},
+        {"raw \x9b2J", false, `raw \x9b2J`},
+        {"<a>\r\n\t<b>\x1b[2J</b>\n</a>", true, "<a>\n\t<b>\\x1b[2J</b>\n</a>"},
+        {"lone\rreturn", true, `lone\x0dreturn`},
+    }
+    for _, tt := range tests {
+        if got := printable(tt.s, tt.lines); got != tt.want {
+            t.Errorf("printable(%q, %v) = %q, want %q", tt.s, tt.lines, got, tt.want)
+        }
+    }
+}
+
+func TestTargetFilter(t *testing.T) {
+    f := targetFilter([]string{"urn:schemas-upnp-org:device:MediaServer:1"})
+    server := gossdp.DeviceTarget("schemas-upnp-org", "MediaServer", 1)
+    renderer := gossdp.DeviceTarget("schemas-upnp-org", "MediaRenderer", 1)
+    tests := []struct {
+        name        string
+        ev          gossdp.Event
+        want        bool
+    }{
+        {"search for all", gossdp.Event{Type: gossdp.EventSearch, Search: &gossdp.SearchMessage{SearchType: gossdp.SearchTarget{Kind: gossdp.TargetAll}}}, true},
+        {"search for the target", gossdp.Event{Type: gossdp.EventSearch, Search: &gossdp.SearchMessage{SearchType: server}}, true},
+        {"search for another", gossdp.Event{Type: gossdp.EventSearch, Search: &gossdp.SearchMessage{SearchType: renderer}}, false},
+        {"alive", gossdp.Event{Type: gossdp.EventAlive, Alive: &gossdp.AliveMessage{SearchType: server}}, true},
+        {"another alive", gossdp.Event{Type: gossdp.EventAlive, Alive: &gossdp.AliveMessage{SearchType: renderer}}, false},
+        {"response", gossdp.Event{Type: gossdp.EventResponse, Response: &gossdp.ResponseMessage{SearchType: server}}, true},
+    }
+    for _, tt := range tests {
+        if got := f.Match(tt.ev); got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func runRelay(args []string) error {
+    var o options
+    fs := newFlagSet("relay", &o)
+    var interfaces, targets stringsFlag
+    fs.Var(&interfaces, "iface", "an interface to relay between. Give at least two")
+    fs.Var(&targets, "st", "only relay this NT or ST. May be repeated")
+    maxHops := fs.Int("max-hops", 4, "drop messages that passed through this many relays")
+    quiet := fs.Bool("q", false, "don't print what is relayed")
+    if _, err := parseArgs(fs, args, 0); err != nil {
+        return err
+    }
+    if len(interfaces) < 2 {
+        fmt.Fprintln(fs.Output(), "Give at least two --iface")
+        fs.Usage()
+        return errUsage
+    }
+
+    r, err := gossdp.NewRelay(interfaces, o.logger())
+    if err != nil {
+        return err
+    }
+    if len(targets) > 0 {
+        r.SetFilter(targetFilter(targets))
+    }
+    r.SetMaxHops(*maxHops)
+    if !*quiet {
+        out := &output{w: os.Stdout, json: o.json, stream: true}
+        r.Subscribe(gossdp.Filter{Types: []gossdp.EventType{gossdp.EventAlive, gossdp.EventBye, gossdp.EventUpdate, gossdp.EventResponse, gossdp.EventSearch}}, func (ev gossdp.Event) {
+            out.add(eventRecord(ev))
+        })
+    }
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    fmt.Fprintf(os.Stderr, "Relaying between %s as %s. Press Ctrl-C to stop\n", strings.Join(interfaces, ", "), r.Id())
+    return r.Run(ctx)
+}
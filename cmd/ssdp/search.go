@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+func runSearch(args []string) error {
+    var o options
+    fs := newFlagSet("search", &o)
+    timeout := fs.Duration("timeout", 5 * time.Second, "how long to wait for responses")
+    mx := fs.Int("mx", 3, "the MX: seconds devices may wait before answering")
+    iface := fs.String("iface", "", "the network interface to search on. Eg. eth0")
+    positional, err := parseArgs(fs, args, 1)
+    if err != nil {
+        return err
+    }
+    st := "ssdp:all"
+    if len(positional) > 0 {
+        st = positional[0]
+    }
+
+    c, err := gossdp.NewSsdpClientWithSlog(nil, o.logger())
+    if err != nil {
+        return err
+    }
+    if *iface != "" {
+        i, err := net.InterfaceByName(*iface)
+        if err != nil {
+            c.Shutdown(context.Background())
+            return err
+        }
+        if err := c.SetInterface(i); err != nil {
+            c.Shutdown(context.Background())
+            return fmt.Errorf("Can't search on %s: %w", *iface, err)
+        }
+    }
+
+    out := &output{w: os.Stdout, json: o.json}
+    // devices often answer on several interfaces, or more than once
+    seen := make(map[string]bool)
+    c.Subscribe(gossdp.Filter{}, func (ev gossdp.Event) {
+        key := ev.Source + " " + ev.Usn().String()
+        if seen[key] {
+            return
+        }
+        seen[key] = true
+        out.add(eventRecord(ev))
+    })
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    ctx, cancelTimeout := context.WithTimeout(ctx, *timeout)
+    defer cancelTimeout()
+    done := make(chan error, 1)
+    go func () {
+        done <- c.Run(ctx)
+    }()
+    if err := c.Search(st, *mx); err != nil {
+        cancelTimeout()
+        <- done
+        return err
+    }
+    err = <- done
+    // the reader has stopped, so nothing is adding rows
+    out.flush()
+    return err
+}
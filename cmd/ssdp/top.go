@@ -0,0 +1,298 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "os/signal"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// ANSI escapes used to redraw the screen.
+const (
+    clearScreen         = "\x1b[H\x1b[2J"
+    bold                = "\x1b[1m"
+    red                 = "\x1b[31m"
+    dim                 = "\x1b[2m"
+    reset               = "\x1b[0m"
+)
+
+func runTop(args []string) error {
+    var o options
+    fs := newFlagSet("top", &o)
+    refresh := fs.Duration("refresh", 2 * time.Second, "how often the screen is redrawn")
+    linger := fs.Duration("linger", 30 * time.Second, "how long devices stay listed after they leave")
+    st := fs.String("st", "ssdp:all", "what to search for at start, and on r")
+    if _, err := parseArgs(fs, args, 0); err != nil {
+        return err
+    }
+    // logging would scroll the screen away
+    logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+    if o.verbose {
+        logger = o.logger()
+    }
+
+    s, err := gossdp.NewSsdpWithSlog(nil, logger)
+    if err != nil {
+        return err
+    }
+    c, err := gossdp.NewSsdpClientWithSlog(nil, logger)
+    if err != nil {
+        s.Shutdown(context.Background())
+        return err
+    }
+    registry := gossdp.NewRegistry()
+    registry.SetLinger(*linger)
+    registry.Attach(s)
+    registry.AttachClient(c)
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    serverDone := make(chan error, 1)
+    clientDone := make(chan error, 1)
+    go func () {
+        serverDone <- s.Run(ctx)
+    }()
+    go func () {
+        clientDone <- c.Run(ctx)
+    }()
+    c.Search(*st, 2)
+
+    t := &top{
+        registry        : registry,
+        client          : c,
+        st              : *st,
+        out             : os.Stdout,
+    }
+    t.loop(ctx, cancel, *refresh)
+    cancel()
+    <- clientDone
+    return <- serverDone
+}
+
+// The state of the screen.
+type top struct {
+    registry            *gossdp.Registry
+    client              *gossdp.ClientSsdp
+    st                  string
+    out                 io.Writer
+    // the devices as last listed, so a number picks one
+    listed              []gossdp.RegisteredDevice
+    // set while showing one device
+    detail              *gossdp.RegisteredDevice
+    description         string
+}
+
+func (t *top) loop(ctx context.Context, quit func(), refresh time.Duration) {
+    lines := make(chan string)
+    go func () {
+        scanner := bufio.NewScanner(os.Stdin)
+        for scanner.Scan() {
+            lines <- scanner.Text()
+        }
+        close(lines)
+    }()
+    described := make(chan description)
+    ticker := time.NewTicker(refresh)
+    defer ticker.Stop()
+
+    t.draw()
+    for {
+        select {
+        case <- ctx.Done():
+            return
+        case <- ticker.C:
+            if t.detail == nil {
+                t.draw()
+            }
+        case desc := <- described:
+            if t.detail == nil || t.detail.Uuid != desc.uuid {
+                // the user moved on before it arrived
+                continue
+            }
+            t.description = desc.text
+            t.draw()
+        case line, ok := <- lines:
+            if !ok {
+                // stdin closed. Keep watching until interrupted
+                lines = nil
+                continue
+            }
+            line = strings.TrimSpace(line)
+            switch {
+            case line == "q":
+                quit()
+                return
+            case t.detail != nil:
+                // any line goes back to the list
+                t.detail = nil
+            case line == "r":
+                t.client.Search(t.st, 2)
+            default:
+                n, err := strconv.Atoi(line)
+                if err != nil || n < 1 || n > len(t.listed) {
+                    break
+                }
+                d := t.listed[n - 1]
+                t.detail = &d
+                t.description = "Fetching " + d.Location + " ..."
+                go func () {
+                    desc := description{uuid: d.Uuid, text: describe(ctx, d.Location)}
+                    select {
+                    case described <- desc:
+                    case <- ctx.Done():
+                    }
+                }()
+            }
+            t.draw()
+        }
+    }
+}
+
+// A fetched description, and the device it is for.
+type description struct {
+    uuid                string
+    text                string
+}
+
+func (t *top) draw() {
+    buf := bytes.Buffer{}
+    buf.WriteString(clearScreen)
+    if t.detail != nil {
+        t.drawDetail(&buf)
+    } else {
+        t.drawList(&buf)
+    }
+    t.out.Write(buf.Bytes())
+}
+
+func (t *top) drawList(buf *bytes.Buffer) {
+    now := time.Now()
+    t.listed = t.registry.Devices()
+    fmt.Fprintf(buf, "%sssdp top%s  %d devices  %s\n", bold, reset, len(t.listed), now.Format("15:04:05"))
+    fmt.Fprintf(buf, "Enter a number to describe a device, r to search for %s, q to quit\n\n", t.st)
+    fmt.Fprintf(buf, "%s%3s  %-36s  %-15s  %7s  %8s  %s%s\n", bold, "#", "UUID", "SOURCE", "AGE", "EXPIRES", "SERVER", reset)
+    for i, d := range t.listed {
+        color := ""
+        remaining := formatAge(d.Expires.Sub(now))
+        switch {
+        case !d.ByeAt.IsZero():
+            color, remaining = red, "byebye"
+        case d.Gone(now):
+            color, remaining = dim, "expired"
+        }
+        fmt.Fprintf(buf, "%s%3d  %-36s  %-15s  %7s  %8s  %s%s\n", color, i + 1, truncate(printable(d.Uuid, false), 36),
+            truncate(strings.Join(d.Sources, ","), 15), formatAge(now.Sub(d.FirstSeen)), remaining, printable(d.Server, false), reset)
+        for _, svc := range d.Services {
+            fmt.Fprintf(buf, "%s%5s%-60s  %s%s\n", color, "", printable(svc.SearchType.String(), false), printable(svc.Location, false), reset)
+        }
+    }
+}
+
+func (t *top) drawDetail(buf *bytes.Buffer) {
+    d := t.detail
+    fmt.Fprintf(buf, "%suuid:%s%s\n", bold, printable(d.Uuid, false), reset)
+    fmt.Fprintf(buf, "Sources   %s\n", strings.Join(d.Sources, ", "))
+    fmt.Fprintf(buf, "Server    %s\n", printable(d.Server, false))
+    fmt.Fprintf(buf, "Location  %s\n", printable(d.Location, false))
+    fmt.Fprintf(buf, "BootId    %d  ConfigId %d\n", d.BootId, d.ConfigId)
+    fmt.Fprintf(buf, "\nPress Enter to go back, q to quit\n\n")
+    buf.WriteString(t.description)
+    buf.WriteString("\n")
+}
+
+// Fetches a description, returning its XML or why it could not be fetched,
+// ready to print.
+func describe(ctx context.Context, location string) string {
+    ctx, cancel := context.WithTimeout(ctx, 10 * time.Second)
+    defer cancel()
+    desc, err := gossdp.FetchDescription(ctx, location)
+    if err != nil {
+        return red + printable(fmt.Sprintf("Error fetching %s: %v", location, err), false) + reset
+    }
+    return printable(indentXML(desc.Raw), true)
+}
+
+// Re-indents XML, which devices often send on a single line. Returns it as is if it can't.
+func indentXML(raw []byte) string {
+    out := bytes.Buffer{}
+    dec := xml.NewDecoder(bytes.NewReader(raw))
+    enc := xml.NewEncoder(&out)
+    enc.Indent("", "  ")
+    for {
+        tok, err := dec.RawToken()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return string(raw)
+        }
+        switch v := tok.(type) {
+        case xml.CharData:
+            if len(bytes.TrimSpace(v)) == 0 {
+                continue
+            }
+        case xml.StartElement:
+            // keep prefixes as they were, rather than have the encoder invent namespaces
+            v.Name = rawName(v.Name)
+            for i := range v.Attr {
+                v.Attr[i].Name = rawName(v.Attr[i].Name)
+            }
+            tok = v
+        case xml.EndElement:
+            v.Name = rawName(v.Name)
+            tok = v
+        case xml.ProcInst:
+            // the encoder only allows the declaration first, and we are
+            if v.Target == "xml" {
+                continue
+            }
+        }
+        if err := enc.EncodeToken(tok); err != nil {
+            return string(raw)
+        }
+    }
+    if err := enc.Flush(); err != nil {
+        return string(raw)
+    }
+    return out.String()
+}
+
+func rawName(n xml.Name) xml.Name {
+    if n.Space == "" {
+        return n
+    }
+    return xml.Name{Local: n.Space + ":" + n.Local}
+}
+
+func formatAge(d time.Duration) string {
+    if d < 0 {
+        d = 0
+    }
+    d = d.Round(time.Second)
+    switch {
+    case d < time.Minute:
+        return fmt.Sprintf("%ds", int(d.Seconds()))
+    case d < time.Hour:
+        return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds()) % 60)
+    }
+    return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes()) % 60)
+}
+
+func truncate(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    return s[:n - 1] + "~"
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+
+func TestDescribeEscapes(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/xml")
+        w.Write([]byte("<?xml version=\"1.0\"?>\r\n<root xmlns=\"urn:schemas-upnp-org:device-1-0\"><specVersion><major>1</major><minor>0</minor></specVersion>" +
+            "<device><deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType><friendlyName>TV<!--\x1b]0;pwned\x07--></friendlyName>" +
+            "<UDN>uuid:2fac1234-31f8-11b4-a222-08002b34c003</UDN></device></root>"))
+    }))
+    defer srv.Close()
+
+    got := describe(context.Background(), srv.URL)
+    if strings.ContainsAny(got, "\x1b\x07\r") {
+        t.Errorf("control characters were printed: %q", got)
+    }
+    if !strings.Contains(got, "\n  <specVersion>") {
+        t.Errorf("lost the indenting: %q", got)
+    }
+
+    got = describe(context.Background(), srv.URL + "/\x1b[2J")
+    if !strings.HasPrefix(got, red) || strings.Contains(got[len(red):], "\x1b[2J") {
+        t.Errorf("control characters were printed in the error: %q", got)
+    }
+}
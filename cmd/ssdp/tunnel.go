@@ -0,0 +1,216 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "net"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// How long to wait before dialing the peer again.
+const redialDelay = 5 * time.Second
+
+func runTunnel(args []string) error {
+    var o options
+    fs := newFlagSet("tunnel", &o)
+    listen := fs.String("listen", "", "wait for the peer on this host:port")
+    connect := fs.String("connect", "", "connect to the peer at this host:port")
+    udp := fs.Bool("udp", false, "tunnel over UDP rather than TCP")
+    proxy := fs.String("proxy", "", "rewrite LOCATION of tunnelled messages to go through this reverse proxy URL")
+    var targets stringsFlag
+    fs.Var(&targets, "st", "only tunnel this NT or ST. May be repeated")
+    maxHops := fs.Int("max-hops", 4, "drop messages that passed through this many relays and tunnels")
+    quiet := fs.Bool("q", false, "don't print what is heard")
+    secretFile := fs.String("secret-file", "", "sign and check every message with the secret in this file. Both ends need it")
+    sharePort := fs.Bool("share-port", false, "share port 1900 with other tunnels on this host, eg. to try both ends on loopback")
+    var allow stringsFlag
+    fs.Var(&allow, "allow", "only take messages from peers in this network, eg. 203.0.113.7/32. May be repeated")
+    if _, err := parseArgs(fs, args, 0); err != nil {
+        return err
+    }
+    if (*listen == "") == (*connect == "") {
+        fmt.Fprintln(fs.Output(), "Give one of --listen or --connect")
+        fs.Usage()
+        return errUsage
+    }
+    if *listen != "" && *secretFile == "" && len(allow) == 0 {
+        // otherwise anyone who reaches the port can multicast onto our network
+        fmt.Fprintln(fs.Output(), "--listen needs --secret-file or --allow")
+        fs.Usage()
+        return errUsage
+    }
+    var secret []byte
+    if *secretFile != "" {
+        data, err := os.ReadFile(*secretFile)
+        if err != nil {
+            return err
+        }
+        secret = bytes.TrimSpace(data)
+        if len(secret) == 0 {
+            return fmt.Errorf("%s is empty", *secretFile)
+        }
+    }
+    var allowed []*net.IPNet
+    for _, a := range allow {
+        _, n, err := net.ParseCIDR(a)
+        if err != nil {
+            return fmt.Errorf("Bad --allow %q: %w", a, err)
+        }
+        allowed = append(allowed, n)
+    }
+
+    newSsdp := gossdp.NewSsdpWithSlog
+    if *sharePort {
+        newSsdp = gossdp.NewSharedSsdp
+    }
+    s, err := newSsdp(nil, o.logger())
+    if err != nil {
+        return err
+    }
+    if !*quiet {
+        out := &output{w: os.Stdout, json: o.json, stream: true}
+        s.Subscribe(gossdp.Filter{Types: []gossdp.EventType{gossdp.EventAlive, gossdp.EventBye, gossdp.EventUpdate, gossdp.EventResponse, gossdp.EventSearch}}, func (ev gossdp.Event) {
+            out.add(eventRecord(ev))
+        })
+    }
+    setup := func (t *gossdp.Tunnel) *gossdp.Tunnel {
+        t.SetSecret(secret)
+        t.SetAllowedPeers(allowed)
+        if *proxy != "" {
+            t.SetRewriteLocation(gossdp.ProxyLocation(*proxy))
+        }
+        if len(targets) > 0 {
+            t.SetFilter(targetFilter(targets))
+        }
+        t.SetMaxHops(*maxHops)
+        return t
+    }
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+    done := make(chan error, 1)
+    go func () {
+        done <- s.Run(ctx)
+    }()
+
+    var tunnelErr error
+    switch {
+    case *udp:
+        tunnelErr = tunnelUdp(ctx, s, *listen, *connect, setup)
+    case *listen != "":
+        tunnelErr = tunnelListen(ctx, s, *listen, allowed, setup)
+    default:
+        tunnelErr = tunnelConnect(ctx, s, *connect, setup)
+    }
+    cancel()
+    if err := <- done; err != nil {
+        return err
+    }
+    return tunnelErr
+}
+
+func tunnelUdp(ctx context.Context, s *gossdp.Ssdp, listen, connect string, setup func(*gossdp.Tunnel) *gossdp.Tunnel) error {
+    var peer net.Addr
+    local := listen
+    if connect != "" {
+        addr, err := net.ResolveUDPAddr("udp", connect)
+        if err != nil {
+            return err
+        }
+        peer = addr
+        local = ":0"
+    }
+    conn, err := net.ListenPacket("udp", local)
+    if err != nil {
+        return err
+    }
+    t := setup(gossdp.NewPacketTunnel(s, conn, peer))
+    if peer == nil {
+        fmt.Fprintf(os.Stderr, "Tunnel %s waiting for the peer on %s. Press Ctrl-C to stop\n", t.Id(), conn.LocalAddr())
+    } else {
+        fmt.Fprintf(os.Stderr, "Tunnel %s sending to %s. Press Ctrl-C to stop\n", t.Id(), peer)
+    }
+    return t.Run(ctx)
+}
+
+// Serves each peer that connects in its own goroutine, so one that never says hello
+// can't hold up the others. The tunnel drops it after 10 seconds.
+func tunnelListen(ctx context.Context, s *gossdp.Ssdp, listen string, allowed []*net.IPNet, setup func(*gossdp.Tunnel) *gossdp.Tunnel) error {
+    l, err := net.Listen("tcp", listen)
+    if err != nil {
+        return err
+    }
+    go func () {
+        <- ctx.Done()
+        l.Close()
+    }()
+    fmt.Fprintf(os.Stderr, "Waiting for the tunnel peer on %s. Press Ctrl-C to stop\n", l.Addr())
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            return err
+        }
+        if !allowedPeer(allowed, conn.RemoteAddr()) {
+            fmt.Fprintf(os.Stderr, "Refused tunnel peer %s\n", conn.RemoteAddr())
+            conn.Close()
+            continue
+        }
+        t := setup(gossdp.NewTunnel(s, conn))
+        fmt.Fprintf(os.Stderr, "Tunnel %s connected to %s\n", t.Id(), conn.RemoteAddr())
+        go func () {
+            if err := t.Run(ctx); err != nil {
+                fmt.Fprintln(os.Stderr, err)
+            }
+        }()
+    }
+}
+
+// Keeps dialing the peer until ctx is done.
+func tunnelConnect(ctx context.Context, s *gossdp.Ssdp, connect string, setup func(*gossdp.Tunnel) *gossdp.Tunnel) error {
+    var d net.Dialer
+    for {
+        conn, err := d.DialContext(ctx, "tcp", connect)
+        if err == nil {
+            t := setup(gossdp.NewTunnel(s, conn))
+            fmt.Fprintf(os.Stderr, "Tunnel %s connected to %s. Press Ctrl-C to stop\n", t.Id(), conn.RemoteAddr())
+            err = t.Run(ctx)
+        }
+        if ctx.Err() != nil {
+            return nil
+        }
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+        }
+        select {
+        case <- ctx.Done():
+            return nil
+        case <- time.After(redialDelay):
+        }
+    }
+}
+
+func allowedPeer(allowed []*net.IPNet, addr net.Addr) bool {
+    if len(allowed) == 0 {
+        return true
+    }
+    tcp, ok := addr.(*net.TCPAddr)
+    if !ok {
+        return false
+    }
+    for _, n := range allowed {
+        if n.Contains(tcp.IP) {
+            return true
+        }
+    }
+    return false
+}
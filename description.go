@@ -0,0 +1,104 @@
+package gossdp
+
+import (
+    "context"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "net/http"
+)
+
+
+// The most of a device description we will read.
+const maxDescriptionSize = 1 << 20
+
+// The device description XML a LOCATION points to. See the UPnP device architecture, section 2.
+type DeviceDescription struct {
+    SpecVersion         SpecVersion     `xml:"specVersion"`
+    // Deprecated by UPnP 1.1, but still sent by many devices
+    URLBase             string          `xml:"URLBase,omitempty"`
+    Device              Device          `xml:"device"`
+    // The XML as it was fetched
+    Raw                 []byte          `xml:"-" json:"-"`
+}
+
+type SpecVersion struct {
+    Major               int             `xml:"major"`
+    Minor               int             `xml:"minor"`
+}
+
+// A root or embedded device.
+type Device struct {
+    DeviceType          string          `xml:"deviceType"`
+    FriendlyName        string          `xml:"friendlyName"`
+    Manufacturer        string          `xml:"manufacturer"`
+    ManufacturerURL     string          `xml:"manufacturerURL,omitempty"`
+    ModelDescription    string          `xml:"modelDescription,omitempty"`
+    ModelName           string          `xml:"modelName"`
+    ModelNumber         string          `xml:"modelNumber,omitempty"`
+    ModelURL            string          `xml:"modelURL,omitempty"`
+    SerialNumber        string          `xml:"serialNumber,omitempty"`
+    // uuid:device-UUID
+    UDN                 string          `xml:"UDN"`
+    UPC                 string          `xml:"UPC,omitempty"`
+    Icons               []Icon          `xml:"iconList>icon"`
+    Services            []Service       `xml:"serviceList>service"`
+    Devices             []Device        `xml:"deviceList>device"`
+    PresentationURL     string          `xml:"presentationURL,omitempty"`
+}
+
+type Icon struct {
+    Mimetype            string          `xml:"mimetype"`
+    Width               int             `xml:"width"`
+    Height              int             `xml:"height"`
+    Depth               int             `xml:"depth"`
+    URL                 string          `xml:"url"`
+}
+
+type Service struct {
+    ServiceType         string          `xml:"serviceType"`
+    ServiceId           string          `xml:"serviceId"`
+    SCPDURL             string          `xml:"SCPDURL"`
+    ControlURL          string          `xml:"controlURL"`
+    EventSubURL         string          `xml:"eventSubURL"`
+}
+
+// Reads a device description.
+func ParseDescription(data []byte) (*DeviceDescription, error) {
+    var desc DeviceDescription
+    if err := xml.Unmarshal(data, &desc); err != nil {
+        return nil, fmt.Errorf("Error parsing device description: %w", err)
+    }
+    desc.Raw = data
+    return &desc, nil
+}
+
+// Fetches and parses the device description at location, the LOCATION of a NOTIFY or response.
+func FetchDescription(ctx context.Context, location string) (*DeviceDescription, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("Error fetching device description: %s", resp.Status)
+    }
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxDescriptionSize))
+    if err != nil {
+        return nil, err
+    }
+    return ParseDescription(data)
+}
+
+// Every device in the tree, the root first.
+func (d Device) All() []Device {
+    devices := []Device{d}
+    for _, e := range d.Devices {
+        devices = append(devices, e.All()...)
+    }
+    return devices
+}
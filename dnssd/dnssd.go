@@ -0,0 +1,485 @@
+/*
+Bridges SSDP and DNS-SD, so devices found one way can be discovered the other.
+
+Devices heard over SSDP whose type has a Mapping are published as DNS-SD services.
+DNS-SD services of a mapped type are advertised over SSDP with AdvertiseServer.
+The package speaks neither mDNS nor DNS itself: give it a Publisher and a Browser
+adapting the mDNS library of your choice.
+
+    b, err := dnssd.New(s, []dnssd.Mapping{
+        {Urn: "urn:schemas-upnp-org:device:Printer:1", Service: "_ipp._tcp"},
+    }, slog.Default())
+    b.SetPublisher(myResponder)
+    b.SetBrowser(myBrowser)
+    go s.Run(ctx)
+    b.Run(ctx)
+*/
+package dnssd
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "net/url"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// The extension header on what the bridge advertises over SSDP, naming the bridge.
+const bridgeHeader = "X-DNSSD-BRIDGE"
+
+// The TXT key on what the bridge publishes over DNS-SD, naming the bridge.
+const bridgeKey = "bridge"
+
+// The namespace of the UUIDs DNS-SD services are advertised with.
+const uuidNamespace = "5b1c0f52-8f0e-5d4a-b0a4-3c9e2d7f6a18"
+
+// How often the bridge looks for SSDP devices that expired.
+const syncInterval = time.Second
+
+// Pairs an SSDP device or service type with a DNS-SD service type.
+type Mapping struct {
+    // Eg. urn:schemas-upnp-org:device:Printer:1. Devices of a later version also match
+    Urn                 string
+    // Eg. _ipp._tcp
+    Service             string
+
+    target              gossdp.SearchTarget
+}
+
+// A DNS-SD service instance.
+type Service struct {
+    // The instance name. Eg. Office Printer
+    Instance            string
+    // Eg. _ipp._tcp
+    Type                string
+    // Eg. local.
+    Domain              string
+    // The host name or address the service runs on
+    Host                string
+    Port                int
+    // Addresses of Host, when the browser resolved them
+    Addrs               []net.IP
+    // The TXT record
+    Text                map[string]string
+}
+
+// Publishes services over DNS-SD. Implementations adapt an mDNS responder.
+type Publisher interface {
+    Publish(s Service) error
+    Unpublish(s Service) error
+}
+
+// Browses for DNS-SD services. Implementations adapt an mDNS resolver.
+type Browser interface {
+    // Calls found for every instance of serviceType in domain, and lost when one goes
+    // away, until ctx is done. found may be called again for an instance that changed.
+    Browse(ctx context.Context, serviceType, domain string, found, lost func(Service)) error
+}
+
+// Moves services between SSDP and DNS-SD.
+type Bridge struct {
+    s                   *gossdp.Ssdp
+    mappings            []Mapping
+    logger              *slog.Logger
+    publisher           Publisher
+    browser             Browser
+    domain              string
+    maxAge              int
+    instanceName        func(d gossdp.RegisteredDevice, svc gossdp.RegisteredService) string
+    id                  string
+    registry            *gossdp.Registry
+    changed             chan struct{}
+    lock                sync.Mutex
+    // what we published over DNS-SD, by USN
+    published           map[string]Service
+    // the device UUIDs we advertise over SSDP
+    advertised          map[string]bool
+    // device UUIDs other bridges advertise
+    bridged             map[string]bool
+}
+
+// Creates a bridge for s, moving the mapped types. Nothing is bridged until
+// SetPublisher or SetBrowser is called.
+func New(s *gossdp.Ssdp, mappings []Mapping, lg *slog.Logger) (*Bridge, error) {
+    if lg == nil {
+        lg = slog.Default()
+    }
+    b := &Bridge{
+        s                   : s,
+        logger              : lg,
+        domain              : "local.",
+        maxAge              : 1800,
+        instanceName        : defaultInstanceName,
+        registry            : gossdp.NewRegistry(),
+        changed             : make(chan struct{}, 1),
+        published           : make(map[string]Service),
+        advertised          : make(map[string]bool),
+        bridged             : make(map[string]bool),
+    }
+    for _, m := range mappings {
+        st, err := gossdp.ParseSearchTarget(m.Urn)
+        if err != nil || !st.IsUrn() {
+            return nil, fmt.Errorf("Can't map %s: not a device or service URN", m.Urn)
+        }
+        if !validServiceType(m.Service) {
+            return nil, fmt.Errorf("Can't map %s: not a DNS-SD service type like _name._tcp", m.Service)
+        }
+        m.target = st
+        b.mappings = append(b.mappings, m)
+    }
+    if len(b.mappings) == 0 {
+        return nil, errors.New("A bridge needs at least one mapping")
+    }
+    id := make([]byte, 4)
+    rand.Read(id)
+    b.id = hex.EncodeToString(id)
+    return b, nil
+}
+
+// Publishes SSDP devices over DNS-SD with p. Must be called before Run.
+func (b *Bridge) SetPublisher(p Publisher) {
+    b.publisher = p
+}
+
+// Advertises the DNS-SD services br finds over SSDP. Must be called before Run.
+func (b *Bridge) SetBrowser(br Browser) {
+    b.browser = br
+}
+
+// The DNS-SD domain to browse and publish in. Defaults to local.
+// Must be called before Run.
+func (b *Bridge) SetDomain(domain string) {
+    b.domain = domain
+}
+
+// The max-age of what is advertised over SSDP. Defaults to 1800 seconds.
+// Must be called before Run.
+func (b *Bridge) SetMaxAge(seconds int) {
+    b.maxAge = seconds
+}
+
+// Names the DNS-SD instance published for an SSDP service. Instance names must be
+// unique within a service type. Defaults to the device type and the start of its
+// UUID, eg. Printer 2fac1234. Must be called before Run.
+func (b *Bridge) SetInstanceName(name func(d gossdp.RegisteredDevice, svc gossdp.RegisteredService) string) {
+    b.instanceName = name
+}
+
+// Bridges until ctx is done, then withdraws everything it published and advertised.
+// The Ssdp must be run separately.
+func (b *Bridge) Run(ctx context.Context) error {
+    detach := b.registry.Attach(b.s)
+    defer detach()
+    unsubscribe := b.s.Subscribe(gossdp.Filter{}, b.heard)
+    defer unsubscribe()
+
+    var browsing sync.WaitGroup
+    if b.browser != nil {
+        seen := make(map[string]bool)
+        for _, m := range b.mappings {
+            if seen[serviceKey(m.Service)] {
+                continue
+            }
+            seen[serviceKey(m.Service)] = true
+            browsing.Add(1)
+            go func (serviceType string) {
+                defer browsing.Done()
+                err := b.browser.Browse(ctx, serviceType, b.domain, b.found, b.lost)
+                if err != nil && ctx.Err() == nil {
+                    b.logger.Warn("Error browsing DNS-SD", "service", serviceType, "error", err)
+                }
+            }(m.Service)
+        }
+    }
+
+    ticker := time.NewTicker(syncInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <- ctx.Done():
+            browsing.Wait()
+            b.withdraw()
+            return nil
+        case <- b.changed:
+            b.sync()
+        case <- ticker.C:
+            b.sync()
+        }
+    }
+}
+
+// Runs on the reader goroutine.
+func (b *Bridge) heard(ev gossdp.Event) {
+    var header string
+    switch ev.Type {
+    case gossdp.EventAlive:
+        header = ev.Alive.RawRequest.Header.Get(bridgeHeader)
+    case gossdp.EventResponse:
+        header = ev.Response.RawResponse.Header.Get(bridgeHeader)
+    }
+    if header != "" && header != b.id {
+        b.lock.Lock()
+        b.bridged[strings.ToLower(ev.Usn().Uuid)] = true
+        b.lock.Unlock()
+    }
+    select {
+    case b.changed <- struct{}{}:
+    default:
+    }
+}
+
+// Brings what we published over DNS-SD in line with the registry.
+func (b *Bridge) sync() {
+    if b.publisher == nil {
+        return
+    }
+    now := time.Now()
+    want := make(map[string]Service)
+    b.lock.Lock()
+    for _, d := range b.registry.Devices() {
+        uuid := strings.ToLower(d.Uuid)
+        if d.Gone(now) || b.advertised[uuid] || b.bridged[uuid] {
+            continue
+        }
+        for _, svc := range d.Services {
+            m, ok := b.mappingForUrn(svc.SearchType)
+            if !ok || !now.Before(svc.Expires) {
+                continue
+            }
+            service, err := b.service(d, svc, m)
+            if err != nil {
+                b.logger.Debug("Can't publish device", "usn", svc.Usn.String(), "error", err)
+                continue
+            }
+            want[svc.Usn.String()] = service
+        }
+    }
+    b.lock.Unlock()
+
+    for usn, service := range b.published {
+        if _, ok := want[usn]; ok {
+            continue
+        }
+        if err := b.publisher.Unpublish(service); err != nil {
+            b.logger.Warn("Error unpublishing DNS-SD service", "instance", service.Instance, "service", service.Type, "error", err)
+        }
+        delete(b.published, usn)
+    }
+    for usn, service := range want {
+        old, ok := b.published[usn]
+        if ok && reflect.DeepEqual(old, service) {
+            continue
+        }
+        if ok {
+            b.publisher.Unpublish(old)
+            delete(b.published, usn)
+        }
+        if err := b.publisher.Publish(service); err != nil {
+            b.logger.Warn("Error publishing DNS-SD service", "instance", service.Instance, "service", service.Type, "error", err)
+            continue
+        }
+        b.published[usn] = service
+        b.logger.Debug("Published DNS-SD service", "instance", service.Instance, "service", service.Type, "usn", usn)
+    }
+}
+
+// The DNS-SD service for one USN of an SSDP device.
+func (b *Bridge) service(d gossdp.RegisteredDevice, svc gossdp.RegisteredService, m Mapping) (Service, error) {
+    u, err := url.Parse(svc.Location)
+    if err != nil || u.Hostname() == "" {
+        return Service{}, fmt.Errorf("Bad LOCATION %q", svc.Location)
+    }
+    port, err := strconv.Atoi(u.Port())
+    if err != nil {
+        port = 80
+        if u.Scheme == "https" {
+            port = 443
+        }
+    }
+    service := Service{
+        Instance            : b.instanceName(d, svc),
+        Type                : m.Service,
+        Domain              : b.domain,
+        Host                : u.Hostname(),
+        Port                : port,
+        Text                : map[string]string{
+            "path"          : u.RequestURI(),
+            "usn"           : svc.Usn.String(),
+            "location"      : svc.Location,
+            bridgeKey       : b.id,
+        },
+    }
+    if ip := net.ParseIP(service.Host); ip != nil {
+        service.Addrs = []net.IP{ip}
+    }
+    if svc.Server != "" {
+        service.Text["server"] = svc.Server
+    }
+    return service, nil
+}
+
+func defaultInstanceName(d gossdp.RegisteredDevice, svc gossdp.RegisteredService) string {
+    uuid := d.Uuid
+    if len(uuid) > 8 {
+        uuid = uuid[:8]
+    }
+    return svc.SearchType.Type + " " + uuid
+}
+
+// Advertises a DNS-SD service over SSDP. Called by the browser.
+func (b *Bridge) found(service Service) {
+    if _, ok := service.Text[bridgeKey]; ok {
+        // one of ours, or another bridge's
+        return
+    }
+    m, ok := b.mappingForService(service.Type)
+    if !ok {
+        return
+    }
+    location, err := serviceLocation(service)
+    if err != nil {
+        b.logger.Debug("Can't advertise DNS-SD service", "instance", service.Instance, "service", service.Type, "error", err)
+        return
+    }
+    uuid := instanceUuid(service, b.domain)
+    b.lock.Lock()
+    b.advertised[uuid] = true
+    b.lock.Unlock()
+    err = b.s.AdvertiseServer(gossdp.AdvertisableServer{
+        ServiceType         : m.Urn,
+        DeviceUuid          : uuid,
+        Location            : location,
+        MaxAge              : b.maxAge,
+        ExtraHeaders        : map[string]string{
+            bridgeHeader        : b.id,
+            // any host on the LAN can name an instance
+            "X-DNSSD-INSTANCE"  : withoutControls(service.Instance),
+        },
+    })
+    if err != nil {
+        b.logger.Warn("Error advertising DNS-SD service", "instance", service.Instance, "service", service.Type, "error", err)
+        return
+    }
+    b.logger.Debug("Advertised DNS-SD service", "instance", service.Instance, "service", service.Type, "usn", "uuid:" + uuid)
+}
+
+// Stops advertising a DNS-SD service that went away. Called by the browser.
+func (b *Bridge) lost(service Service) {
+    uuid := instanceUuid(service, b.domain)
+    b.lock.Lock()
+    ok := b.advertised[uuid]
+    delete(b.advertised, uuid)
+    b.lock.Unlock()
+    if ok {
+        b.s.RemoveServer(uuid)
+    }
+}
+
+func (b *Bridge) withdraw() {
+    for usn, service := range b.published {
+        b.publisher.Unpublish(service)
+        delete(b.published, usn)
+    }
+    b.lock.Lock()
+    advertised := b.advertised
+    b.advertised = make(map[string]bool)
+    b.lock.Unlock()
+    for uuid := range advertised {
+        b.s.RemoveServer(uuid)
+    }
+}
+
+func (b *Bridge) mappingForUrn(st gossdp.SearchTarget) (Mapping, bool) {
+    for _, m := range b.mappings {
+        if _, ok := gossdp.DefaultSearchMatcher.MatchSearch(m.target, gossdp.AdvertisableServer{ServiceType: st.String()}); ok {
+            return m, true
+        }
+    }
+    return Mapping{}, false
+}
+
+func (b *Bridge) mappingForService(serviceType string) (Mapping, bool) {
+    key := serviceKey(serviceType)
+    for _, m := range b.mappings {
+        if serviceKey(m.Service) == key {
+            return m, true
+        }
+    }
+    return Mapping{}, false
+}
+
+
+// Where to find a DNS-SD service: its location TXT, or http://host:port/path.
+// It must be an http or https URL, as it comes from whoever answered over mDNS.
+func serviceLocation(service Service) (string, error) {
+    location := service.Text["location"]
+    if location == "" {
+        host := strings.TrimSuffix(service.Host, ".")
+        for _, ip := range service.Addrs {
+            if ip.To4() != nil {
+                host = ip.String()
+                break
+            }
+        }
+        if host == "" || service.Port <= 0 {
+            return "", errors.New("No host or port")
+        }
+        path := service.Text["path"]
+        if !strings.HasPrefix(path, "/") {
+            path = "/" + path
+        }
+        location = "http://" + net.JoinHostPort(host, strconv.Itoa(service.Port)) + path
+    }
+    // url.Parse refuses control characters, so the LOCATION header can't be split
+    u, err := url.Parse(location)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return "", fmt.Errorf("Bad location %q", location)
+    }
+    return location, nil
+}
+
+// Drops the C0 and C1 control characters, such as CR and LF, from a header value.
+func withoutControls(v string) string {
+    return strings.Map(func (r rune) rune {
+        if r < 0x20 || (r >= 0x7f && r < 0xa0) {
+            return -1
+        }
+        return r
+    }, v)
+}
+
+// A name based UUID for a DNS-SD instance, so it is advertised with the same UUID every time.
+func instanceUuid(service Service, domain string) string {
+    if service.Domain != "" {
+        domain = service.Domain
+    }
+    name := strings.ToLower(service.Instance + "." + serviceKey(service.Type) + "." + strings.TrimSuffix(domain, "."))
+    uuid, _ := gossdp.NameUuid(uuidNamespace, name)
+    return uuid
+}
+
+// Compares service types ignoring case and any domain. _IPP._tcp.local. is _ipp._tcp
+func serviceKey(serviceType string) string {
+    parts := strings.Split(strings.ToLower(strings.TrimSuffix(serviceType, ".")), ".")
+    if len(parts) > 2 {
+        parts = parts[:2]
+    }
+    return strings.Join(parts, ".")
+}
+
+func validServiceType(serviceType string) bool {
+    parts := strings.Split(serviceKey(serviceType), ".")
+    return len(parts) == 2 && len(parts[0]) > 1 && strings.HasPrefix(parts[0], "_") &&
+        (parts[1] == "_tcp" || parts[1] == "_udp")
+}
@@ -0,0 +1,258 @@
+package dnssd
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+type testPublisher struct {
+    published           []Service
+    unpublished         []Service
+}
+
+func (p *testPublisher) Publish(s Service) error {
+    p.published = append(p.published, s)
+    return nil
+}
+
+func (p *testPublisher) Unpublish(s Service) error {
+    p.unpublished = append(p.unpublished, s)
+    return nil
+}
+
+// A bridge over an Ssdp replaying an empty capture, so nothing touches the network.
+func testBridge(t *testing.T) *Bridge {
+    var capture bytes.Buffer
+    if _, err := gossdp.NewPcapngWriter(&capture); err != nil {
+        t.Fatal(err)
+    }
+    s, err := gossdp.NewSsdpReplay(nil, &capture, slog.Default())
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func () {
+        ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+        defer cancel()
+        s.Shutdown(ctx)
+    })
+    b, err := New(s, []Mapping{
+        {Urn: "urn:schemas-upnp-org:device:Printer:1", Service: "_ipp._tcp"},
+    }, slog.Default())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+func TestServiceKey(t *testing.T) {
+    tests := []struct {
+        serviceType     string
+        want            string
+    }{
+        {"_ipp._tcp", "_ipp._tcp"},
+        {"_IPP._TCP", "_ipp._tcp"},
+        {"_ipp._tcp.local.", "_ipp._tcp"},
+        {"_ipp._tcp.example.com", "_ipp._tcp"},
+        {"_ipp", "_ipp"},
+    }
+    for _, tt := range tests {
+        if got := serviceKey(tt.serviceType); got != tt.want {
+            t.Errorf("serviceKey(%q) = %q, want %q", tt.serviceType, got, tt.want)
+        }
+    }
+}
+
+func TestValidServiceType(t *testing.T) {
+    tests := []struct {
+        serviceType     string
+        want            bool
+    }{
+        {"_ipp._tcp", true},
+        {"_dns-sd._udp", true},
+        {"_ipp._tcp.local.", true},
+        {"_ipp", false},
+        {"ipp._tcp", false},
+        {"_._tcp", false},
+        {"_ipp._sctp", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        if got := validServiceType(tt.serviceType); got != tt.want {
+            t.Errorf("validServiceType(%q) = %v, want %v", tt.serviceType, got, tt.want)
+        }
+    }
+}
+
+func TestInstanceUuid(t *testing.T) {
+    office := Service{Instance: "Office Printer", Type: "_ipp._tcp"}
+    uuid := instanceUuid(office, "local.")
+    if len(uuid) != 36 {
+        t.Fatalf("got %q, want a UUID", uuid)
+    }
+    same := []Service{
+        office,
+        {Instance: "office printer", Type: "_IPP._tcp"},
+        {Instance: "Office Printer", Type: "_ipp._tcp.local.", Domain: "local"},
+    }
+    for _, s := range same {
+        if got := instanceUuid(s, "local."); got != uuid {
+            t.Errorf("%+v: got %s, want %s", s, got, uuid)
+        }
+    }
+    other := []Service{
+        {Instance: "Lobby Printer", Type: "_ipp._tcp"},
+        {Instance: "Office Printer", Type: "_ipps._tcp"},
+        {Instance: "Office Printer", Type: "_ipp._tcp", Domain: "example.com."},
+    }
+    for _, s := range other {
+        if got := instanceUuid(s, "local."); got == uuid {
+            t.Errorf("%+v: got the same UUID as %+v", s, office)
+        }
+    }
+}
+
+func TestServiceLocation(t *testing.T) {
+    tests := []struct {
+        name            string
+        service         Service
+        want            string
+        ok              bool
+    }{
+        {"TXT", Service{Text: map[string]string{"location": "http://10.0.0.5:631/ipp"}}, "http://10.0.0.5:631/ipp", true},
+        {"host and port", Service{Host: "printer.local.", Port: 631, Text: map[string]string{"path": "ipp"}}, "http://printer.local:631/ipp", true},
+        {"no port", Service{Host: "printer.local."}, "", false},
+        {"CRLF", Service{Text: map[string]string{"location": "http://10.0.0.5:631/\r\nX-Injected: 1"}}, "", false},
+        {"not http", Service{Text: map[string]string{"location": "file:///etc/passwd"}}, "", false},
+        {"CRLF in path", Service{Host: "printer.local.", Port: 631, Text: map[string]string{"path": "ipp\r\nX-Injected: 1"}}, "", false},
+    }
+    for _, tt := range tests {
+        got, err := serviceLocation(tt.service)
+        if (err == nil) != tt.ok {
+            t.Errorf("%s: error %v", tt.name, err)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestBridgeFound(t *testing.T) {
+    b := testBridge(t)
+    b.found(Service{
+        Instance            : "Office\r\nX-Injected: 1",
+        Type                : "_ipp._tcp",
+        Host                : "printer.local.",
+        Port                : 631,
+    })
+    // another bridge's, or one with a bad location, is not advertised
+    b.found(Service{
+        Instance            : "Bridged",
+        Type                : "_ipp._tcp",
+        Host                : "printer.local.",
+        Port                : 631,
+        Text                : map[string]string{bridgeKey: "0badf00d"},
+    })
+    b.found(Service{
+        Instance            : "Split",
+        Type                : "_ipp._tcp",
+        Text                : map[string]string{"location": "http://10.0.0.5/\r\nX-Injected: 1"},
+    })
+    // nor is an unmapped type
+    b.found(Service{Instance: "Web", Type: "_http._tcp", Host: "web.local.", Port: 80})
+
+    servers := b.s.Servers()
+    if len(servers) != 1 {
+        t.Fatalf("got %d servers, want 1", len(servers))
+    }
+    ads := servers[0].Server
+    if ads.DeviceUuid != instanceUuid(Service{Instance: "Office\r\nX-Injected: 1", Type: "_ipp._tcp"}, "local.") {
+        t.Errorf("uuid %s", ads.DeviceUuid)
+    }
+    if ads.Location != "http://printer.local:631/" {
+        t.Errorf("location %q", ads.Location)
+    }
+    if got := ads.ExtraHeaders["X-DNSSD-INSTANCE"]; got != "OfficeX-Injected: 1" {
+        t.Errorf("instance header %q", got)
+    }
+    if got := ads.ExtraHeaders[bridgeHeader]; got != b.id {
+        t.Errorf("bridge header %q, want %q", got, b.id)
+    }
+}
+
+func testAlive(uuid, bridge string) gossdp.Event {
+    req := &http.Request{Header: http.Header{}}
+    if bridge != "" {
+        req.Header.Set(bridgeHeader, bridge)
+    }
+    st := gossdp.DeviceTarget("schemas-upnp-org", "Printer", 1)
+    return gossdp.Event{
+        Type                : gossdp.EventAlive,
+        Time                : time.Now(),
+        Source              : "10.0.0.7:1900",
+        Alive               : &gossdp.AliveMessage{
+            SearchType      : st,
+            Usn             : gossdp.NewUSN(uuid, st),
+            Location        : "http://10.0.0.7:631/description.xml",
+            MaxAge          : 1800,
+            BootId          : -1,
+            ConfigId        : -1,
+            RawRequest      : req,
+        },
+    }
+}
+
+func TestBridgeSync(t *testing.T) {
+    const (
+        plain   = "2fac1234-31f8-11b4-a222-08002b34c003"
+        other   = "3fac1234-31f8-11b4-a222-08002b34c003"
+        ours    = "4fac1234-31f8-11b4-a222-08002b34c003"
+    )
+    b := testBridge(t)
+    p := &testPublisher{}
+    b.SetPublisher(p)
+    b.advertised[ours] = true
+    for _, ev := range []gossdp.Event{
+        testAlive(plain, ""),
+        // advertised by another bridge, so it came from DNS-SD
+        testAlive(other, "0badf00d"),
+        // advertised by this bridge
+        testAlive(strings.ToUpper(ours), b.id),
+    } {
+        b.heard(ev)
+        b.registry.Handle(ev)
+    }
+    b.sync()
+
+    if len(p.published) != 1 {
+        t.Fatalf("published %d services, want 1: %+v", len(p.published), p.published)
+    }
+    service := p.published[0]
+    if service.Type != "_ipp._tcp" || service.Host != "10.0.0.7" || service.Port != 631 {
+        t.Errorf("published %+v", service)
+    }
+    if service.Text["usn"] != "uuid:" + plain + "::urn:schemas-upnp-org:device:Printer:1" {
+        t.Errorf("usn TXT %q", service.Text["usn"])
+    }
+    if service.Text[bridgeKey] != b.id {
+        t.Errorf("bridge TXT %q, want %q", service.Text[bridgeKey], b.id)
+    }
+
+    // nothing changed, so nothing is published again
+    b.sync()
+    if len(p.published) != 1 || len(p.unpublished) != 0 {
+        t.Errorf("after a second sync: published %d, unpublished %d", len(p.published), len(p.unpublished))
+    }
+    b.withdraw()
+    if len(p.unpublished) != 1 {
+        t.Errorf("withdraw unpublished %d, want 1", len(p.unpublished))
+    }
+}
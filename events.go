@@ -0,0 +1,254 @@
+package gossdp
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+
+// What an Event reports.
+type EventType int
+
+const (
+    // A NOTIFY ssdp:alive. Event.Alive is set
+    EventAlive EventType = iota + 1
+    // A NOTIFY ssdp:byebye. Event.Bye is set
+    EventBye
+    // A NOTIFY ssdp:update. Event.Update is set
+    EventUpdate
+    // A response to one of our M-SEARCH requests. Event.Response is set
+    EventResponse
+    // An M-SEARCH someone else sent. Event.Search is set
+    EventSearch
+)
+
+func (t EventType) String() string {
+    switch t {
+    case EventAlive:
+        return "alive"
+    case EventBye:
+        return "byebye"
+    case EventUpdate:
+        return "update"
+    case EventResponse:
+        return "response"
+    case EventSearch:
+        return "search"
+    }
+    return "unknown"
+}
+
+// Something we received. Exactly one of the messages is set, as Type says.
+type Event struct {
+    Type                EventType           `json:"type"`
+    // When we received it
+    Time                time.Time           `json:"time"`
+    // The host:port it came from
+    Source              string              `json:"source"`
+    // The network interface it arrived on, when the platform tells us
+    Interface           string              `json:"interface,omitempty"`
+
+    Alive               *AliveMessage       `json:"alive,omitempty"`
+    Bye                 *ByeMessage         `json:"bye,omitempty"`
+    Update              *UpdateMessage      `json:"update,omitempty"`
+    Response            *ResponseMessage    `json:"response,omitempty"`
+    Search              *SearchMessage      `json:"search,omitempty"`
+}
+
+// What happens when the Events channel is full.
+type OverflowPolicy int
+
+const (
+    // Discard the oldest buffered event to make room. Counted by DroppedEvents
+    OverflowDropOldest OverflowPolicy = iota
+    // Discard the new event. Counted by DroppedEvents
+    OverflowDropNewest
+    // Wait for room. This stalls packet reading until the consumer catches up
+    OverflowBlock
+)
+
+// The buffer of the Events channel, unless SetEventBuffer says otherwise.
+const defaultEventBuffer = 64
+
+// The Events channel and its delivery rules.
+// Events are only published from the reader goroutine.
+type eventStream struct {
+    lock                sync.Mutex
+    ch                  chan Event
+    size                int
+    policy              OverflowPolicy
+    dropped             atomic.Uint64
+    // closed by stop, to release a publisher blocked on a full channel
+    done                chan struct{}
+    stopped             bool
+    closed              bool
+}
+
+func (e *eventStream) configure(size int, policy OverflowPolicy) {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    if size < 1 {
+        size = 1
+    }
+    e.size = size
+    e.policy = policy
+}
+
+func (e *eventStream) channel() <-chan Event {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    if e.ch == nil {
+        if e.size == 0 {
+            e.size = defaultEventBuffer
+        }
+        e.ch = make(chan Event, e.size)
+        e.done = make(chan struct{})
+        if e.stopped {
+            close(e.done)
+        }
+        if e.closed {
+            close(e.ch)
+        }
+    }
+    return e.ch
+}
+
+func (e *eventStream) publish(ev Event) {
+    e.lock.Lock()
+    ch, done, policy := e.ch, e.done, e.policy
+    e.lock.Unlock()
+    if ch == nil {
+        // nobody asked for events
+        return
+    }
+
+    switch policy {
+    case OverflowBlock:
+        select {
+        case ch <- ev:
+        case <- done:
+            e.dropped.Add(1)
+        }
+    case OverflowDropNewest:
+        select {
+        case ch <- ev:
+        default:
+            e.dropped.Add(1)
+        }
+    default:
+        for {
+            select {
+            case ch <- ev:
+                return
+            default:
+            }
+            select {
+            case <- ch:
+                e.dropped.Add(1)
+            default:
+            }
+        }
+    }
+}
+
+// Releases a blocked publisher. Called before the reader is stopped.
+func (e *eventStream) stop() {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    if e.done != nil && !e.stopped {
+        close(e.done)
+    }
+    e.stopped = true
+}
+
+// Closes the channel. Called once the reader has exited.
+func (e *eventStream) close() {
+    e.stop()
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    if e.ch != nil && !e.closed {
+        close(e.ch)
+    }
+    e.closed = true
+}
+
+
+// Returns a channel of everything we receive: NOTIFY messages, responses to our
+// searches, and M-SEARCH requests from others. It is an alternative to SsdpListener
+// that does not run on the reader goroutine, so a slow consumer only loses events
+// (see SetEventBuffer) rather than stalling the socket.
+// The channel is closed when the server shuts down.
+func (s *Ssdp) Events() <-chan Event {
+    return s.events.channel()
+}
+
+// Sets the size of the Events buffer, and what happens when it is full.
+// Must be called before Events. Defaults to 64 events, dropping the oldest.
+func (s *Ssdp) SetEventBuffer(size int, policy OverflowPolicy) {
+    s.events.configure(size, policy)
+}
+
+// How many events were discarded because the Events channel was full.
+func (s *Ssdp) DroppedEvents() uint64 {
+    return s.events.dropped.Load()
+}
+
+// Like Ssdp.Events. The client only receives responses.
+func (c *ClientSsdp) Events() <-chan Event {
+    return c.events.channel()
+}
+
+// Like Ssdp.SetEventBuffer.
+func (c *ClientSsdp) SetEventBuffer(size int, policy OverflowPolicy) {
+    c.events.configure(size, policy)
+}
+
+// Like Ssdp.DroppedEvents.
+func (c *ClientSsdp) DroppedEvents() uint64 {
+    return c.events.dropped.Load()
+}
+
+// Hands what we received to the subscribers, the listener and the Events channel.
+func (s *Ssdp) dispatch(ev Event) {
+    ev.Time = time.Now()
+    ev.Interface = s.reading.Interface
+    s.subscribers.publish(ev)
+    // don't notify for people we aren't listening to
+    if ev.Type != EventSearch && !s.isListeningFor(ev.Usn().Target.String()) {
+        return
+    }
+    s.notifyListener(ev)
+    s.events.publish(ev)
+}
+
+func (s *Ssdp) notifyListener(ev Event) {
+    if s.listener == nil {
+        return
+    }
+    switch ev.Type {
+    case EventAlive:
+        s.listener.NotifyAlive(*ev.Alive)
+    case EventBye:
+        s.listener.NotifyBye(*ev.Bye)
+    case EventUpdate:
+        if l, ok := s.listener.(UpdateListener); ok {
+            l.NotifyUpdate(*ev.Update)
+        }
+    case EventResponse:
+        s.listener.Response(*ev.Response)
+    case EventSearch:
+        if l, ok := s.listener.(SearchListener); ok {
+            l.NotifySearch(*ev.Search)
+        }
+    }
+}
+
+func (c *ClientSsdp) dispatch(ev Event) {
+    ev.Time = time.Now()
+    c.subscribers.publish(ev)
+    if c.listener != nil && ev.Type == EventResponse {
+        c.listener.Response(*ev.Response)
+    }
+    c.events.publish(ev)
+}
@@ -0,0 +1,150 @@
+package gossdp
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "golang.org/x/net/http/httpguts"
+)
+
+
+// Returned for header names that are not HTTP tokens, and values with control
+// characters. A CR or LF would let the value start headers, or messages, of its own.
+var ErrInvalidHeader = errors.New("Invalid header. Names must be HTTP tokens and values must not hold control characters")
+
+
+// The headers we read into message fields. Everything else is an extension header.
+var standardHeaders = map[string]bool{
+    "Host": true,
+    "Nt": true,
+    "Nts": true,
+    "Usn": true,
+    "St": true,
+    "Location": true,
+    "Cache-Control": true,
+    "Server": true,
+    "Ext": true,
+    "Date": true,
+    "Man": true,
+    "Mx": true,
+    "User-Agent": true,
+    "Content-Length": true,
+    "Cpfn.upnp.org": true,
+    "Cpuuid.upnp.org": true,
+    "Bootid.upnp.org": true,
+    "Configid.upnp.org": true,
+    "Nextbootid.upnp.org": true,
+}
+
+// Returns the headers that are not part of the basic SSDP messages.
+// Eg. OPT, 01-NLS, X-User-Agent, SECURELOCATION.UPNP.ORG or vendor specific headers.
+func extensionHeaders(h http.Header) http.Header {
+    extra := http.Header{}
+    for k, v := range h {
+        if standardHeaders[http.CanonicalHeaderKey(k)] {
+            continue
+        }
+        extra[k] = v
+    }
+    return extra
+}
+
+// Reads a numeric header, such as BOOTID.UPNP.ORG. -1 if it is missing or invalid.
+func headerInt(h http.Header, key string) int {
+    v, err := strconv.Atoi(strings.TrimSpace(h.Get(key)))
+    if err != nil {
+        return -1
+    }
+    return v
+}
+
+// Adds the extra headers to an outgoing message.
+// They never replace the headers we set ourselves.
+func addExtraHeaders(heads map[string]string, extra map[string]string) {
+    for k, v := range extra {
+        if _, ok := heads[strings.ToUpper(k)]; ok {
+            continue
+        }
+        heads[k] = v
+    }
+}
+
+// Checks a header we are about to send.
+func checkHeader(name, value string) error {
+    if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+        return fmt.Errorf("%w: %q", ErrInvalidHeader, name)
+    }
+    return nil
+}
+
+// Checks what is copied into the headers of NOTIFYs and responses.
+func checkExtraHeaders(location string, extra map[string]string) error {
+    if err := checkHeader("LOCATION", location); err != nil {
+        return err
+    }
+    for k, v := range extra {
+        if err := checkHeader(k, v); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func copyExtraHeaders(extra map[string]string) map[string]string {
+    if extra == nil {
+        return nil
+    }
+    c := make(map[string]string, len(extra))
+    for k, v := range extra {
+        c[k] = v
+    }
+    return c
+}
+
+// The non-standard headers of the NOTIFY.
+func (m AliveMessage) ExtraHeaders() http.Header {
+    if m.RawRequest == nil {
+        // eg. a message restored from JSON
+        return http.Header{}
+    }
+    return extensionHeaders(m.RawRequest.Header)
+}
+
+// The non-standard headers of the NOTIFY.
+func (m ByeMessage) ExtraHeaders() http.Header {
+    if m.RawRequest == nil {
+        // eg. a message restored from JSON
+        return http.Header{}
+    }
+    return extensionHeaders(m.RawRequest.Header)
+}
+
+// The non-standard headers of the NOTIFY.
+func (m UpdateMessage) ExtraHeaders() http.Header {
+    if m.RawRequest == nil {
+        // eg. a message restored from JSON
+        return http.Header{}
+    }
+    return extensionHeaders(m.RawRequest.Header)
+}
+
+// The non-standard headers of the response.
+func (m ResponseMessage) ExtraHeaders() http.Header {
+    if m.RawResponse == nil {
+        // eg. a message restored from JSON
+        return http.Header{}
+    }
+    return extensionHeaders(m.RawResponse.Header)
+}
+
+// The non-standard headers of the M-SEARCH.
+func (m SearchMessage) ExtraHeaders() http.Header {
+    if m.RawRequest == nil {
+        // eg. a message restored from JSON
+        return http.Header{}
+    }
+    return extensionHeaders(m.RawRequest.Header)
+}
@@ -0,0 +1,64 @@
+package gossdp
+
+import (
+    "errors"
+    "testing"
+)
+
+
+func TestAdvertisableServerCheckHeaders(t *testing.T) {
+    valid := AdvertisableServer{
+        ServiceType         : "urn:schemas-upnp-org:device:MediaServer:1",
+        DeviceUuid          : "2fac1234-31f8-11b4-a222-08002b34c003",
+        Location            : "http://192.168.1.20:8080/description.xml",
+        ExtraHeaders        : map[string]string{"OPT": `"http://schemas.upnp.org/upnp/1/0/"; ns=01`, "01-NLS": "1"},
+    }
+    if err := valid.checkHeaders(); err != nil {
+        t.Fatalf("valid server: %v", err)
+    }
+    tests := []struct {
+        name        string
+        change      func(*AdvertisableServer)
+    }{
+        {"CRLF in Location", func (a *AdvertisableServer) { a.Location += "\r\nX-Injected: 1" }},
+        {"LF in ServiceType", func (a *AdvertisableServer) { a.ServiceType += "\nX-Injected: 1" }},
+        {"NUL in a value", func (a *AdvertisableServer) { a.ExtraHeaders = map[string]string{"OPT": "a\x00b"} }},
+        {"CR in a value", func (a *AdvertisableServer) { a.ExtraHeaders = map[string]string{"OPT": "a\rb"} }},
+        {"space in a name", func (a *AdvertisableServer) { a.ExtraHeaders = map[string]string{"X Injected": "1"} }},
+        {"colon in a name", func (a *AdvertisableServer) { a.ExtraHeaders = map[string]string{"X-A: 1\r\nX-B": "1"} }},
+        {"empty name", func (a *AdvertisableServer) { a.ExtraHeaders = map[string]string{"": "1"} }},
+    }
+    for _, tt := range tests {
+        ads := valid
+        tt.change(&ads)
+        if err := ads.checkHeaders(); !errors.Is(err, ErrInvalidHeader) {
+            t.Errorf("%s: got %v, want ErrInvalidHeader", tt.name, err)
+        }
+    }
+}
+
+func TestExtraHeadersWithoutRawMessage(t *testing.T) {
+    // eg. messages restored from JSON
+    for name, h := range map[string]func() int{
+        "alive"             : func () int { return len(AliveMessage{}.ExtraHeaders()) },
+        "byebye"            : func () int { return len(ByeMessage{}.ExtraHeaders()) },
+        "update"            : func () int { return len(UpdateMessage{}.ExtraHeaders()) },
+        "response"          : func () int { return len(ResponseMessage{}.ExtraHeaders()) },
+        "search"            : func () int { return len(SearchMessage{}.ExtraHeaders()) },
+    } {
+        if n := h(); n != 0 {
+            t.Errorf("%s: %d headers, want none", name, n)
+        }
+    }
+}
+
+func TestSearchInvalidTarget(t *testing.T) {
+    // rejected before anything is queued, so the client needn't be running
+    c := &ClientSsdp{}
+    if err := c.Search("ssdp:all\r\nX-Injected: 1", 3); !errors.Is(err, ErrInvalidHeader) {
+        t.Errorf("Search: got %v, want ErrInvalidHeader", err)
+    }
+    if err := c.SearchUnicast("ssdp:all\nX-Injected: 1", "192.168.1.20"); !errors.Is(err, ErrInvalidHeader) {
+        t.Errorf("SearchUnicast: got %v, want ErrInvalidHeader", err)
+    }
+}
@@ -0,0 +1,311 @@
+/*
+Serves what SSDP discovered as JSON over HTTP, for programs that are not written in Go.
+
+    GET  /devices           the devices in the registry
+    GET  /devices/{uuid}    one device. 404 if it is not known
+    POST /search?st=&mx=    multicasts an M-SEARCH. Responses show up in /devices and /events.
+                            429 when over the limit set by SetSearchRateLimit
+    GET  /events            a Server-Sent Events stream of alive, byebye, update and response
+                            events. ?type=alive&type=byebye picks which
+
+Mount it under a prefix with http.StripPrefix.
+
+    h := httpapi.New(gossdp.NewRegistry())
+    h.Attach(s)
+    h.AttachClient(c)
+    h.SetSearcher(c)
+    http.Handle("/ssdp/", http.StripPrefix("/ssdp", h))
+*/
+package httpapi
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// How many events a slow /events client may fall behind before events are dropped for it.
+const streamBuffer = 64
+
+// How often an idle /events stream sends a comment, so proxies keep it open.
+const keepAliveInterval = 15 * time.Second
+
+// POST /search allows one search a second, with bursts of 3, unless SetSearchRateLimit says otherwise.
+const (
+    defaultSearchRate   = 1
+    defaultSearchBurst  = 3
+)
+
+var defaultStreamTypes = []gossdp.EventType{gossdp.EventAlive, gossdp.EventBye, gossdp.EventUpdate, gossdp.EventResponse}
+
+// Sends M-SEARCH requests for POST /search. ClientSsdp is one.
+type Searcher interface {
+    Search(searchTarget string, mx int) error
+}
+
+// The HTTP API. It is an http.Handler.
+type Handler struct {
+    registry            *gossdp.Registry
+    searcher            Searcher
+    // token bucket for POST /search, shared by every client since each search is multicast
+    searchRate          float64
+    searchBurst         float64
+    searchTokens        float64
+    searchLast          time.Time
+    mux                 *http.ServeMux
+    lock                sync.Mutex
+    streams             map[*stream]bool
+}
+
+// One /events client.
+type stream struct {
+    types               []gossdp.EventType
+    events              chan gossdp.Event
+}
+
+// Serves the devices in r. Feed it with Attach and AttachClient, rather than
+// attaching r directly, so /events sees what arrives.
+func New(r *gossdp.Registry) *Handler {
+    h := &Handler{
+        registry            : r,
+        searchRate          : defaultSearchRate,
+        searchBurst         : defaultSearchBurst,
+        searchTokens        : defaultSearchBurst,
+        mux                 : http.NewServeMux(),
+        streams             : make(map[*stream]bool),
+    }
+    h.mux.HandleFunc("GET /devices", h.devices)
+    h.mux.HandleFunc("GET /devices/{uuid}", h.device)
+    h.mux.HandleFunc("POST /search", h.search)
+    h.mux.HandleFunc("GET /events", h.events)
+    return h
+}
+
+// Sends the searches of POST /search with s. Without one, POST /search answers 501.
+func (h *Handler) SetSearcher(s Searcher) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    h.searcher = s
+}
+
+// Limits POST /search to rate searches per second, with bursts of up to burst, across
+// all clients. Searches over the limit answer 429. A rate of 0 or less allows everything.
+func (h *Handler) SetSearchRateLimit(rate float64, burst int) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    if rate < 0 {
+        rate = 0
+    }
+    if burst < 1 {
+        burst = 1
+    }
+    h.searchRate = rate
+    h.searchBurst = float64(burst)
+    h.searchTokens = h.searchBurst
+}
+
+// True if a search may be sent now. Must hold lock.
+func (h *Handler) allowSearch(now time.Time) bool {
+    if h.searchRate == 0 {
+        return true
+    }
+    if !h.searchLast.IsZero() {
+        h.searchTokens += now.Sub(h.searchLast).Seconds() * h.searchRate
+        if h.searchTokens > h.searchBurst {
+            h.searchTokens = h.searchBurst
+        }
+    }
+    h.searchLast = now
+    if h.searchTokens < 1 {
+        return false
+    }
+    h.searchTokens--
+    return true
+}
+
+// Feeds the registry and /events with everything s receives. Call the returned func to stop.
+func (h *Handler) Attach(s *gossdp.Ssdp) (detach func()) {
+    return s.Subscribe(gossdp.Filter{}, h.Handle)
+}
+
+// Feeds the registry and /events with the responses to c's searches. Call the returned func to stop.
+func (h *Handler) AttachClient(c *gossdp.ClientSsdp) (detach func()) {
+    return c.Subscribe(gossdp.Filter{}, h.Handle)
+}
+
+// Records an event and sends it to /events clients.
+func (h *Handler) Handle(ev gossdp.Event) {
+    h.registry.Handle(ev)
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    for st := range h.streams {
+        if !containsType(st.types, ev.Type) {
+            continue
+        }
+        select {
+        case st.events <- ev:
+        default:
+            // the client is too slow. It misses this one
+        }
+    }
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) devices(w http.ResponseWriter, r *http.Request) {
+    devices := h.registry.Devices()
+    if devices == nil {
+        devices = []gossdp.RegisteredDevice{}
+    }
+    writeJSON(w, http.StatusOK, devices)
+}
+
+func (h *Handler) device(w http.ResponseWriter, r *http.Request) {
+    d, ok := h.registry.Device(r.PathValue("uuid"))
+    if !ok {
+        writeError(w, http.StatusNotFound, errors.New("No such device"))
+        return
+    }
+    writeJSON(w, http.StatusOK, d)
+}
+
+func (h *Handler) search(w http.ResponseWriter, r *http.Request) {
+    h.lock.Lock()
+    searcher := h.searcher
+    h.lock.Unlock()
+    if searcher == nil {
+        writeError(w, http.StatusNotImplemented, errors.New("Searching is not enabled"))
+        return
+    }
+    st := r.FormValue("st")
+    if st == "" {
+        st = "ssdp:all"
+    }
+    if strings.ContainsFunc(st, unicode.IsControl) {
+        // a CR or LF would end the M-SEARCH's headers
+        writeError(w, http.StatusBadRequest, fmt.Errorf("Bad st %q", st))
+        return
+    }
+    if _, err := gossdp.ParseSearchTarget(st); err != nil {
+        writeError(w, http.StatusBadRequest, err)
+        return
+    }
+    mx := 3
+    if v := r.FormValue("mx"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, fmt.Errorf("Bad mx %q", v))
+            return
+        }
+        mx = n
+    }
+    h.lock.Lock()
+    allowed := h.allowSearch(time.Now())
+    h.lock.Unlock()
+    if !allowed {
+        w.Header().Set("Retry-After", "1")
+        writeError(w, http.StatusTooManyRequests, errors.New("Too many searches"))
+        return
+    }
+    if err := searcher.Search(st, mx); err != nil {
+        status := http.StatusInternalServerError
+        if errors.Is(err, gossdp.ErrSearchBadMx) || errors.Is(err, gossdp.ErrInvalidHeader) {
+            status = http.StatusBadRequest
+        }
+        writeError(w, status, err)
+        return
+    }
+    writeJSON(w, http.StatusAccepted, map[string]any{"st": st, "mx": mx})
+}
+
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+        return
+    }
+    st := &stream{types: defaultStreamTypes, events: make(chan gossdp.Event, streamBuffer)}
+    if names := r.URL.Query()["type"]; len(names) > 0 {
+        st.types = nil
+        for _, name := range names {
+            var t gossdp.EventType
+            t.UnmarshalText([]byte(name))
+            if t == 0 {
+                writeError(w, http.StatusBadRequest, fmt.Errorf("Unknown event type %q", name))
+                return
+            }
+            if !containsType(defaultStreamTypes, t) {
+                // the registry is fed without searches, so they would never arrive
+                writeError(w, http.StatusBadRequest, fmt.Errorf("Event type %q is not streamed", name))
+                return
+            }
+            st.types = append(st.types, t)
+        }
+    }
+    h.lock.Lock()
+    h.streams[st] = true
+    h.lock.Unlock()
+    defer func () {
+        h.lock.Lock()
+        delete(h.streams, st)
+        h.lock.Unlock()
+    }()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+    keepAlive := time.NewTicker(keepAliveInterval)
+    defer keepAlive.Stop()
+    for {
+        select {
+        case <- r.Context().Done():
+            return
+        case <- keepAlive.C:
+            if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+                return
+            }
+        case ev := <- st.events:
+            data, err := json.Marshal(ev)
+            if err != nil {
+                continue
+            }
+            if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+                return
+            }
+        }
+        flusher.Flush()
+    }
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+    writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func containsType(types []gossdp.EventType, t gossdp.EventType) bool {
+    for _, et := range types {
+        if et == t {
+            return true
+        }
+    }
+    return false
+}
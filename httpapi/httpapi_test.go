@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+    "bufio"
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+type testSearcher struct {
+    searches            []string
+}
+
+func (s *testSearcher) Search(searchTarget string, mx int) error {
+    s.searches = append(s.searches, searchTarget)
+    return nil
+}
+
+func testAlive(uuid string) gossdp.Event {
+    st := gossdp.SearchTarget{Kind: gossdp.TargetRootDevice}
+    return gossdp.Event{
+        Type                : gossdp.EventAlive,
+        Time                : time.Now(),
+        Source              : "192.168.1.20:1900",
+        Alive               : &gossdp.AliveMessage{
+            SearchType      : st,
+            Usn             : gossdp.NewUSN(uuid, st),
+            Location        : "http://192.168.1.20:8080/description.xml",
+            MaxAge          : 1800,
+            BootId          : -1,
+            ConfigId        : -1,
+            RawRequest      : &http.Request{Header: http.Header{}},
+        },
+    }
+}
+
+func serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+    return w
+}
+
+func TestDevice(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    h := New(gossdp.NewRegistry())
+    h.Handle(testAlive(uuid))
+
+    if w := serve(h, "GET", "/devices/" + uuid); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), uuid) {
+        t.Errorf("known device: %d %s", w.Code, w.Body)
+    }
+    if w := serve(h, "GET", "/devices/3fac1234-31f8-11b4-a222-08002b34c003"); w.Code != http.StatusNotFound {
+        t.Errorf("unknown device: got %d, want 404", w.Code)
+    }
+    if w := serve(h, "GET", "/devices"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), uuid) {
+        t.Errorf("devices: %d %s", w.Code, w.Body)
+    }
+}
+
+func TestSearch(t *testing.T) {
+    tests := []struct {
+        name            string
+        query           string
+        want            int
+    }{
+        {"default", "", http.StatusAccepted},
+        {"device", "st=" + url.QueryEscape("urn:schemas-upnp-org:device:MediaServer:1") + "&mx=2", http.StatusAccepted},
+        {"bad mx", "mx=soon", http.StatusBadRequest},
+        {"bad st", "st=" + url.QueryEscape("urn:schemas-upnp-org:device:MediaServer"), http.StatusBadRequest},
+        {"CRLF in st", "st=" + url.QueryEscape("ssdp:all\r\nX-Injected: 1"), http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        h := New(gossdp.NewRegistry())
+        s := &testSearcher{}
+        h.SetSearcher(s)
+        w := serve(h, "POST", "/search?" + tt.query)
+        if w.Code != tt.want {
+            t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+        }
+        if sent := len(s.searches) == 1; sent != (tt.want == http.StatusAccepted) {
+            t.Errorf("%s: sent %v", tt.name, s.searches)
+        }
+    }
+}
+
+func TestSearchWithoutSearcher(t *testing.T) {
+    h := New(gossdp.NewRegistry())
+    if w := serve(h, "POST", "/search"); w.Code != http.StatusNotImplemented {
+        t.Errorf("got %d, want 501", w.Code)
+    }
+}
+
+func TestSearchRateLimit(t *testing.T) {
+    h := New(gossdp.NewRegistry())
+    s := &testSearcher{}
+    h.SetSearcher(s)
+    h.SetSearchRateLimit(0.001, 2)
+    for i := 0; i < 2; i++ {
+        if w := serve(h, "POST", "/search"); w.Code != http.StatusAccepted {
+            t.Fatalf("search %d: got %d, want 202", i, w.Code)
+        }
+    }
+    w := serve(h, "POST", "/search")
+    if w.Code != http.StatusTooManyRequests {
+        t.Fatalf("over the limit: got %d, want 429", w.Code)
+    }
+    if w.Header().Get("Retry-After") == "" {
+        t.Errorf("no Retry-After")
+    }
+    if len(s.searches) != 2 {
+        t.Errorf("sent %d searches, want 2", len(s.searches))
+    }
+
+    h.SetSearchRateLimit(0, 1)
+    for i := 0; i < 5; i++ {
+        if w := serve(h, "POST", "/search"); w.Code != http.StatusAccepted {
+            t.Fatalf("unlimited search %d: got %d, want 202", i, w.Code)
+        }
+    }
+}
+
+func TestEventsType(t *testing.T) {
+    h := New(gossdp.NewRegistry())
+    for _, query := range []string{"type=nonsense", "type=search", "type=alive&type=bogus"} {
+        if w := serve(h, "GET", "/events?" + query); w.Code != http.StatusBadRequest {
+            t.Errorf("%s: got %d, want 400", query, w.Code)
+        }
+    }
+
+    srv := httptest.NewServer(h)
+    defer srv.Close()
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL + "/events?type=alive", nil)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+        t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+    }
+
+    // the stream is registered before its headers are sent
+    h.Handle(testAlive("2fac1234-31f8-11b4-a222-08002b34c003"))
+    line, err := bufio.NewReader(resp.Body).ReadString('\n')
+    if err != nil {
+        t.Fatal(err)
+    }
+    if line != "event: alive\n" {
+        t.Errorf("got %q, want the alive event", line)
+    }
+}
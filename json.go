@@ -0,0 +1,172 @@
+package gossdp
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+)
+
+
+// Search targets are written as they are sent in ST and NT headers.
+func (t SearchTarget) MarshalText() ([]byte, error) {
+    return []byte(t.String()), nil
+}
+
+func (t *SearchTarget) UnmarshalText(text []byte) error {
+    if strings.TrimSpace(string(text)) == "" {
+        *t = SearchTarget{}
+        return nil
+    }
+    *t = toSearchTarget(string(text))
+    return nil
+}
+
+// USNs are written as they are sent in USN headers.
+func (u USN) MarshalText() ([]byte, error) {
+    return []byte(u.String()), nil
+}
+
+func (u *USN) UnmarshalText(text []byte) error {
+    *u = toUSN(string(text))
+    return nil
+}
+
+// Event types are written by name. Eg. alive
+func (t EventType) MarshalText() ([]byte, error) {
+    return []byte(t.String()), nil
+}
+
+func (t *EventType) UnmarshalText(text []byte) error {
+    for et := EventAlive; et <= EventSearch; et++ {
+        if et.String() == string(text) {
+            *t = et
+            return nil
+        }
+    }
+    *t = 0
+    return nil
+}
+
+// The JSON form of the messages. Headers we did not send or receive are left out.
+type messageJSON struct {
+    SearchType          SearchTarget    `json:"st"`
+    Usn                 *USN            `json:"usn,omitempty"`
+    Location            string          `json:"location,omitempty"`
+    MaxAge              *int            `json:"max_age,omitempty"`
+    Server              string          `json:"server,omitempty"`
+    BootId              *int            `json:"boot_id,omitempty"`
+    NextBootId          *int            `json:"next_boot_id,omitempty"`
+    ConfigId            *int            `json:"config_id,omitempty"`
+    // for searches
+    Host                string          `json:"host,omitempty"`
+    Man                 string          `json:"man,omitempty"`
+    MaxWait             *int            `json:"mx,omitempty"`
+    UserAgent           string          `json:"user_agent,omitempty"`
+    ControlPointName    string          `json:"cpfn,omitempty"`
+    ControlPointUuid    string          `json:"cpuuid,omitempty"`
+    Multicast           *bool           `json:"multicast,omitempty"`
+    Source              string          `json:"source,omitempty"`
+    Rejected            string          `json:"rejected,omitempty"`
+    // The extension headers
+    Headers             http.Header     `json:"headers,omitempty"`
+}
+
+// nil for the -1 of a missing header
+func present(v int) *int {
+    if v < 0 {
+        return nil
+    }
+    return &v
+}
+
+func extensionHeadersJSON(h http.Header) http.Header {
+    if h == nil {
+        return nil
+    }
+    extra := extensionHeaders(h)
+    if len(extra) == 0 {
+        return nil
+    }
+    return extra
+}
+
+func (m AliveMessage) MarshalJSON() ([]byte, error) {
+    j := messageJSON{
+        SearchType          : m.SearchType,
+        Usn                 : &m.Usn,
+        Location            : m.Location,
+        MaxAge              : present(m.MaxAge),
+        Server              : m.Server,
+        BootId              : present(m.BootId),
+        ConfigId            : present(m.ConfigId),
+    }
+    if m.RawRequest != nil {
+        j.Headers = extensionHeadersJSON(m.RawRequest.Header)
+    }
+    return json.Marshal(j)
+}
+
+func (m ByeMessage) MarshalJSON() ([]byte, error) {
+    j := messageJSON{
+        SearchType          : m.SearchType,
+        Usn                 : &m.Usn,
+        BootId              : present(m.BootId),
+        ConfigId            : present(m.ConfigId),
+    }
+    if m.RawRequest != nil {
+        j.Headers = extensionHeadersJSON(m.RawRequest.Header)
+    }
+    return json.Marshal(j)
+}
+
+func (m UpdateMessage) MarshalJSON() ([]byte, error) {
+    j := messageJSON{
+        SearchType          : m.SearchType,
+        Usn                 : &m.Usn,
+        Location            : m.Location,
+        BootId              : present(m.BootId),
+        NextBootId          : present(m.NextBootId),
+        ConfigId            : present(m.ConfigId),
+    }
+    if m.RawRequest != nil {
+        j.Headers = extensionHeadersJSON(m.RawRequest.Header)
+    }
+    return json.Marshal(j)
+}
+
+func (m ResponseMessage) MarshalJSON() ([]byte, error) {
+    j := messageJSON{
+        SearchType          : m.SearchType,
+        Usn                 : &m.Usn,
+        Location            : m.Location,
+        MaxAge              : present(m.MaxAge),
+        Server              : m.Server,
+        BootId              : present(m.BootId),
+        ConfigId            : present(m.ConfigId),
+    }
+    if m.RawResponse != nil {
+        j.Headers = extensionHeadersJSON(m.RawResponse.Header)
+    }
+    return json.Marshal(j)
+}
+
+func (m SearchMessage) MarshalJSON() ([]byte, error) {
+    j := messageJSON{
+        SearchType          : m.SearchType,
+        Host                : m.Host,
+        Man                 : m.Man,
+        MaxWait             : &m.MaxWait,
+        UserAgent           : m.UserAgent,
+        ControlPointName    : m.ControlPointName,
+        ControlPointUuid    : m.ControlPointUuid,
+        Multicast           : &m.Multicast,
+        Source              : m.Source,
+    }
+    if m.Rejected != nil {
+        j.Rejected = m.Rejected.Error()
+    }
+    if m.RawRequest != nil {
+        j.Headers = extensionHeadersJSON(m.RawRequest.Header)
+    }
+    return json.Marshal(j)
+}
@@ -0,0 +1,124 @@
+package gossdp
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "net"
+    "strings"
+)
+
+
+// Below slog.LevelDebug. Every packet read or written is logged at this level.
+const LevelTrace = slog.Level(-8)
+
+// The attributes we log with.
+const (
+    // The host:port a message came from
+    logSource               = "source"
+    // The host:port a message is sent to
+    logDestination          = "destination"
+    // The NT or ST of the message
+    logSt                   = "st"
+    logUsn                  = "usn"
+    // The network interface name
+    logInterface            = "interface"
+    // "in" for what we read, "out" for what we write
+    logDirection            = "direction"
+)
+
+// Logs at LevelTrace. Pass addresses as logAddr, so they are only formatted when traced.
+func trace(l *slog.Logger, msg string, args ... any) {
+    l.Log(context.Background(), LevelTrace, msg, args...)
+}
+
+// An address that becomes a string only once a handler resolves it.
+type logAddr struct {
+    addr                    net.Addr
+}
+
+func (a logAddr) LogValue() slog.Value {
+    return slog.StringValue(a.addr.String())
+}
+
+
+// Adapts a LoggerInterface into a slog.Handler, so existing loggers keep working.
+// Records below level are dropped. Attributes are appended to the message as key=value.
+// Levels map to the interface as: below Info is Tracef, then Infof, Warnf and Errorf.
+func NewLoggerHandler(lg LoggerInterface, level slog.Leveler) slog.Handler {
+    if level == nil {
+        level = slog.LevelInfo
+    }
+    return &loggerHandler{lg: lg, level: level}
+}
+
+type loggerHandler struct {
+    lg                      LoggerInterface
+    level                   slog.Leveler
+    // the attributes from WithAttrs, already formatted
+    attrs                   string
+    group                   string
+}
+
+func (h *loggerHandler) Enabled(_ context.Context, level slog.Level) bool {
+    return level >= h.level.Level()
+}
+
+func (h *loggerHandler) Handle(_ context.Context, r slog.Record) error {
+    buf := strings.Builder{}
+    buf.WriteString(r.Message)
+    buf.WriteString(h.attrs)
+    r.Attrs(func (a slog.Attr) bool {
+        writeAttr(&buf, h.group, a)
+        return true
+    })
+    line := buf.String()
+    switch {
+    case r.Level < slog.LevelInfo:
+        h.lg.Tracef("%s", line)
+    case r.Level < slog.LevelWarn:
+        h.lg.Infof("%s", line)
+    case r.Level < slog.LevelError:
+        h.lg.Warnf("%s", line)
+    default:
+        h.lg.Errorf("%s", line)
+    }
+    return nil
+}
+
+func (h *loggerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    buf := strings.Builder{}
+    buf.WriteString(h.attrs)
+    for _, a := range attrs {
+        writeAttr(&buf, h.group, a)
+    }
+    c := *h
+    c.attrs = buf.String()
+    return &c
+}
+
+func (h *loggerHandler) WithGroup(name string) slog.Handler {
+    if name == "" {
+        return h
+    }
+    c := *h
+    c.group = h.group + name + "."
+    return &c
+}
+
+func writeAttr(buf *strings.Builder, group string, a slog.Attr) {
+    a.Value = a.Value.Resolve()
+    if a.Equal(slog.Attr{}) {
+        return
+    }
+    if a.Value.Kind() == slog.KindGroup {
+        if a.Key != "" {
+            group = group + a.Key + "."
+        }
+        for _, ga := range a.Value.Group() {
+            writeAttr(buf, group, ga)
+        }
+        return
+    }
+    fmt.Fprintf(buf, " %s%s=%v", group, a.Key, a.Value)
+}
@@ -0,0 +1,119 @@
+package gossdp
+
+import (
+    "strings"
+)
+
+
+// Decides whether an advertised server answers an M-SEARCH.
+// When it does, it returns the search target to answer with. This becomes the ST
+// of the response, and the USN is built from it and the server's device UUID.
+type SearchMatcher interface {
+    MatchSearch(st SearchTarget, ads AdvertisableServer) (SearchTarget, bool)
+}
+
+// Adapts a function into a SearchMatcher.
+type SearchMatcherFunc func(st SearchTarget, ads AdvertisableServer) (SearchTarget, bool)
+
+func (f SearchMatcherFunc) MatchSearch(st SearchTarget, ads AdvertisableServer) (SearchTarget, bool) {
+    return f(st, ads)
+}
+
+// The matching rules of the UPnP device architecture:
+//
+//  ssdp:all            every server answers with its own service type
+//  upnp:rootdevice     every device answers with upnp:rootdevice
+//  uuid:device-UUID    the device with that UUID answers with its uuid
+//  urn:...:type:v      servers advertising the same domain, kind and type at
+//                      version v or later answer with the requested version.
+//                      All three are compared ignoring case, as devices disagree on it
+//
+// Any other target must equal the advertised service type exactly.
+var DefaultSearchMatcher SearchMatcher = SearchMatcherFunc(matchSearch)
+
+func matchSearch(st SearchTarget, ads AdvertisableServer) (SearchTarget, bool) {
+    advertised := ads.Target()
+    switch st.Kind {
+    case TargetAll:
+        return advertised, true
+    case TargetRootDevice:
+        return st, true
+    case TargetUuid:
+        // answered with the UUID as we advertise it, so the USN isn't uuid:abc::uuid:ABC
+        return UuidTarget(ads.DeviceUuid), strings.EqualFold(st.Uuid, ads.DeviceUuid)
+    case TargetDevice, TargetService, TargetUrn:
+        if !advertised.IsUrn() {
+            return st, false
+        }
+        return st, strings.EqualFold(st.Domain, advertised.Domain) &&
+            strings.EqualFold(st.Category, advertised.Category) &&
+            strings.EqualFold(st.Type, advertised.Type) &&
+            st.Version <= advertised.Version
+    }
+    return st, !st.IsZero() && st.String() == advertised.String()
+}
+
+// Adds a matcher for vendor specific search targets.
+// Custom matchers are consulted in the order they were added, before the
+// DefaultSearchMatcher. The first one that matches decides the response.
+func (s *Ssdp) AddSearchMatcher(m SearchMatcher) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    s.searchMatchers = append(s.searchMatchers, m)
+}
+
+// What we will send in reply to one M-SEARCH.
+type searchMatch struct {
+    ads         *AdvertisableServer
+    st          SearchTarget
+    bootId      int
+}
+
+func (m searchMatch) response() SearchResponse {
+    return SearchResponse{
+        SearchType          : m.st,
+        Usn                 : NewUSN(m.ads.DeviceUuid, m.st),
+        Location            : m.ads.Location,
+        MaxAge              : m.ads.MaxAge,
+        // the SearchObserver may change it, so it must not share the server's map
+        ExtraHeaders        : copyExtraHeaders(m.ads.ExtraHeaders),
+        BootId              : m.bootId,
+        ConfigId            : m.ads.ConfigId,
+    }
+}
+
+// The responses of every server that answers the given search target.
+// Each USN is only answered once, so a device with several services will answer
+// upnp:rootdevice and uuid: searches a single time.
+func (s *Ssdp) matchSearch(st SearchTarget) []SearchResponse {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+
+    var matches []SearchResponse
+    seen := make(map[string]bool)
+    for _, d := range s.devices {
+        for _, ads := range d.services {
+            respondAs, ok := s.matchServer(st, ads)
+            if !ok {
+                continue
+            }
+            usn := NewUSN(ads.DeviceUuid, respondAs).String()
+            if seen[usn] {
+                continue
+            }
+            seen[usn] = true
+            // rendered under the lock, as UpdateServer changes servers in place
+            matches = append(matches, searchMatch{ads, respondAs, d.bootId}.response())
+        }
+    }
+    return matches
+}
+
+func (s *Ssdp) matchServer(st SearchTarget, ads *AdvertisableServer) (SearchTarget, bool) {
+    for _, m := range s.searchMatchers {
+        if respondAs, ok := m.MatchSearch(st, *ads); ok {
+            return respondAs, true
+        }
+    }
+    return DefaultSearchMatcher.MatchSearch(st, *ads)
+}
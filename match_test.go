@@ -0,0 +1,55 @@
+package gossdp
+
+import (
+    "testing"
+)
+
+
+func TestMatchSearch(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    server := AdvertisableServer{ServiceType: "urn:schemas-upnp-org:device:MediaServer:2", DeviceUuid: uuid}
+    tests := []struct {
+        st          string
+        want        string
+        usn         string
+        ok          bool
+    }{
+        {"ssdp:all", "urn:schemas-upnp-org:device:MediaServer:2", "uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer:2", true},
+        {"upnp:rootdevice", "upnp:rootdevice", "uuid:" + uuid + "::upnp:rootdevice", true},
+        {"uuid:" + uuid, "uuid:" + uuid, "uuid:" + uuid, true},
+        // answered with the UUID as advertised
+        {"uuid:2FAC1234-31F8-11B4-A222-08002B34C003", "uuid:" + uuid, "uuid:" + uuid, true},
+        {"uuid:00000000-0000-0000-0000-000000000000", "", "", false},
+        {"urn:schemas-upnp-org:device:MediaServer:2", "urn:schemas-upnp-org:device:MediaServer:2", "uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer:2", true},
+        // older versions are answered with the version asked for
+        {"urn:schemas-upnp-org:device:MediaServer:1", "urn:schemas-upnp-org:device:MediaServer:1", "uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer:1", true},
+        {"urn:schemas-upnp-org:device:MediaServer:3", "", "", false},
+        // the whole urn is compared ignoring case
+        {"urn:Schemas-UPnP-org:device:MediaServer:1", "urn:Schemas-UPnP-org:device:MediaServer:1", "uuid:" + uuid + "::urn:Schemas-UPnP-org:device:MediaServer:1", true},
+        {"urn:schemas-upnp-org:Device:MediaServer:1", "urn:schemas-upnp-org:Device:MediaServer:1", "uuid:" + uuid + "::urn:schemas-upnp-org:Device:MediaServer:1", true},
+        {"urn:schemas-upnp-org:device:mediaserver:1", "urn:schemas-upnp-org:device:mediaserver:1", "uuid:" + uuid + "::urn:schemas-upnp-org:device:mediaserver:1", true},
+        {"urn:schemas-upnp-org:service:MediaServer:1", "", "", false},
+        {"urn:schemas-upnp-org:device:MediaRenderer:1", "", "", false},
+        {"urn:example-com:device:MediaServer:1", "", "", false},
+    }
+    for _, tt := range tests {
+        st, err := ParseSearchTarget(tt.st)
+        if err != nil {
+            t.Fatalf("ParseSearchTarget(%q): %v", tt.st, err)
+        }
+        got, ok := DefaultSearchMatcher.MatchSearch(st, server)
+        if ok != tt.ok {
+            t.Errorf("%s: matched %v, want %v", tt.st, ok, tt.ok)
+            continue
+        }
+        if !ok {
+            continue
+        }
+        if got.String() != tt.want {
+            t.Errorf("%s: answered as %s, want %s", tt.st, got, tt.want)
+        }
+        if usn := NewUSN(server.DeviceUuid, got).String(); usn != tt.usn {
+            t.Errorf("%s: USN %s, want %s", tt.st, usn, tt.usn)
+        }
+    }
+}
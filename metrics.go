@@ -0,0 +1,99 @@
+package gossdp
+
+import (
+    "bytes"
+    "time"
+)
+
+
+// Receives counts of what Ssdp and ClientSsdp read and write.
+// Calls come from the reader, the writer and the advertising timers, so
+// implementations must be safe for concurrent use. See the prometheus package
+// for one that serves the Prometheus text format.
+type Metrics interface {
+    // A datagram was read and understood as a message. method is NOTIFY, M-SEARCH
+    // or RESPONSE. nts is the NTS of a NOTIFY, and empty otherwise
+    PacketIn(method, nts string)
+    // A message was written. Labelled as PacketIn is
+    PacketOut(method, nts string)
+    // A datagram we could not parse, or a message missing required headers
+    ParseFailure()
+    // A response to an M-SEARCH was written
+    ResponseSent()
+    // An M-SEARCH was ignored because its source went over the limit. See SetSearchRateLimit
+    SearchRateLimited()
+    // Writing a message failed
+    WriteError()
+    // How many services we advertise, reported whenever it changes
+    RegistrySize(n int)
+    // The time from reading an M-SEARCH to writing a response to it. This includes
+    // the random delay within MX
+    ResponseLatency(d time.Duration)
+}
+
+// Counts nothing. Used until SetMetrics is called.
+type noMetrics struct {}
+
+func (noMetrics) PacketIn(method, nts string) {}
+func (noMetrics) PacketOut(method, nts string) {}
+func (noMetrics) ParseFailure() {}
+func (noMetrics) ResponseSent() {}
+func (noMetrics) SearchRateLimited() {}
+func (noMetrics) WriteError() {}
+func (noMetrics) RegistrySize(n int) {}
+func (noMetrics) ResponseLatency(d time.Duration) {}
+
+// Reports to m. Must be called before Run. nil stops reporting.
+func (s *Ssdp) SetMetrics(m Metrics) {
+    if m == nil {
+        m = noMetrics{}
+    }
+    s.metrics = m
+}
+
+// Like Ssdp.SetMetrics.
+func (c *ClientSsdp) SetMetrics(m Metrics) {
+    if m == nil {
+        m = noMetrics{}
+    }
+    c.metrics = m
+}
+
+// Reports how many services are advertised. Must hold interactionLock.
+func (s *Ssdp) reportRegistrySize() {
+    n := 0
+    for _, d := range s.devices {
+        n += len(d.services)
+    }
+    s.metrics.RegistrySize(n)
+}
+
+// The method and NTS of a message we built, for PacketOut.
+func messageKind(msg []byte) (method, nts string) {
+    line, rest, _ := bytes.Cut(msg, []byte("\r\n"))
+    if bytes.HasPrefix(line, []byte("HTTP/")) {
+        return "RESPONSE", ""
+    }
+    m, _, _ := bytes.Cut(line, []byte(" "))
+    method = string(m)
+    for _, h := range bytes.Split(rest, []byte("\r\n")) {
+        k, v, ok := bytes.Cut(h, []byte(":"))
+        if ok && string(bytes.ToUpper(bytes.TrimSpace(k))) == "NTS" {
+            return method, string(bytes.TrimSpace(v))
+        }
+    }
+    return method, ""
+}
+
+// Counts a message the writer sent.
+func countSent(m Metrics, msg writeMessage) {
+    method, nts := messageKind(msg.message)
+    m.PacketOut(method, nts)
+    if method != "RESPONSE" {
+        return
+    }
+    m.ResponseSent()
+    if !msg.received.IsZero() {
+        m.ResponseLatency(time.Since(msg.received))
+    }
+}
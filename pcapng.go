@@ -0,0 +1,485 @@
+package gossdp
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+    "sync"
+    "time"
+)
+
+
+// pcapng block types and options we use. See https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+    pcapngSectionHeader     = 0x0A0D0D0A
+    pcapngInterface         = 1
+    pcapngSimplePacket      = 3
+    pcapngEnhancedPacket    = 6
+    pcapngByteOrderMagic    = 0x1A2B3C4D
+
+    pcapngOptEnd            = 0
+    pcapngOptIfName         = 2
+    pcapngOptIfTsresol      = 9
+    pcapngOptEpbFlags       = 2
+
+    // inbound and outbound in the direction bits of epb_flags
+    pcapngInbound           = 1
+    pcapngOutbound          = 2
+
+    // the classic pcap magic numbers, in microseconds and nanoseconds
+    pcapMagicMicro          = 0xA1B2C3D4
+    pcapMagicNano           = 0xA1B23C4D
+
+    linkTypeNull            = 0
+    linkTypeEthernet        = 1
+    linkTypeRaw             = 101
+    linkTypeLinuxSll        = 113
+    linkTypeIpv4            = 228
+    linkTypeLinuxSll2       = 276
+)
+
+// The largest record or block a capture may hold. Datagrams are at most 64 KiB, plus
+// link, IP and UDP headers and block options. Anything bigger is a corrupt length.
+const maxCaptureBlock = 64 * 1024 + 1024
+
+var (
+    ErrNotCapture = errors.New("Not a pcap or pcapng capture")
+    ErrBadCapture = errors.New("Malformed capture")
+)
+
+
+// Writes packets as a pcapng capture, which Wireshark and tcpdump open.
+// Datagrams are written as raw IPv4 packets, with IPv4 and UDP headers made up from
+// their addresses. Each distinct Packet.Interface gets its own interface in the capture.
+// Safe for concurrent use, so one writer can capture several Ssdp and ClientSsdp.
+type PcapngWriter struct {
+    lock                sync.Mutex
+    w                   io.Writer
+    interfaces          map[string]uint32
+    err                 error
+}
+
+// Starts a capture, writing its section header to w.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+    p := &PcapngWriter{w: w, interfaces: make(map[string]uint32)}
+    body := make([]byte, 16)
+    binary.LittleEndian.PutUint32(body[0:], pcapngByteOrderMagic)
+    binary.LittleEndian.PutUint16(body[4:], 1)
+    binary.LittleEndian.PutUint16(body[6:], 0)
+    // the section length is unknown
+    binary.LittleEndian.PutUint64(body[8:], 0xFFFFFFFFFFFFFFFF)
+    if err := p.writeBlock(pcapngSectionHeader, body); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// Appends the packet to the capture. Errors are kept for Err, and stop further writes.
+func (p *PcapngWriter) CapturePacket(pk Packet) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if p.err != nil {
+        return
+    }
+    id, ok := p.interfaces[pk.Interface]
+    if !ok {
+        id = uint32(len(p.interfaces))
+        if p.err = p.writeInterface(pk.Interface); p.err != nil {
+            return
+        }
+        p.interfaces[pk.Interface] = id
+    }
+
+    data := ipv4Datagram(pk.Source, pk.Destination, pk.Data)
+    micros := uint64(pk.Time.UnixMicro())
+    body := make([]byte, 20, 20 + len(data) + 16)
+    binary.LittleEndian.PutUint32(body[0:], id)
+    binary.LittleEndian.PutUint32(body[4:], uint32(micros >> 32))
+    binary.LittleEndian.PutUint32(body[8:], uint32(micros))
+    binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+    binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+    body = append(body, pad4(data)...)
+    flags := uint32(pcapngInbound)
+    if pk.Outbound {
+        flags = pcapngOutbound
+    }
+    body = appendOption(body, pcapngOptEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+    body = appendOption(body, pcapngOptEnd, nil)
+    p.err = p.writeBlock(pcapngEnhancedPacket, body)
+}
+
+// The first error writing the capture, if any.
+func (p *PcapngWriter) Err() error {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    return p.err
+}
+
+func (p *PcapngWriter) writeInterface(name string) error {
+    body := make([]byte, 8)
+    binary.LittleEndian.PutUint16(body[0:], linkTypeRaw)
+    // no snap length
+    binary.LittleEndian.PutUint32(body[4:], 0)
+    if name != "" {
+        body = appendOption(body, pcapngOptIfName, []byte(name))
+    }
+    body = appendOption(body, pcapngOptEnd, nil)
+    return p.writeBlock(pcapngInterface, body)
+}
+
+func (p *PcapngWriter) writeBlock(blockType uint32, body []byte) error {
+    total := uint32(12 + len(body))
+    block := make([]byte, 0, total)
+    block = binary.LittleEndian.AppendUint32(block, blockType)
+    block = binary.LittleEndian.AppendUint32(block, total)
+    block = append(block, body...)
+    block = binary.LittleEndian.AppendUint32(block, total)
+    _, err := p.w.Write(block)
+    return err
+}
+
+func appendOption(body []byte, code uint16, value []byte) []byte {
+    body = binary.LittleEndian.AppendUint16(body, code)
+    body = binary.LittleEndian.AppendUint16(body, uint16(len(value)))
+    return append(body, pad4(value)...)
+}
+
+func pad4(b []byte) []byte {
+    if len(b) % 4 == 0 {
+        return b
+    }
+    return append(b[:len(b):len(b)], make([]byte, 4 - len(b) % 4)...)
+}
+
+// Wraps a UDP payload in IPv4 and UDP headers. The UDP checksum is left out, as IPv4 allows.
+func ipv4Datagram(src, dst *net.UDPAddr, payload []byte) []byte {
+    srcIp, srcPort := udpv4(src)
+    dstIp, dstPort := udpv4(dst)
+    d := make([]byte, 28, 28 + len(payload))
+    d[0] = 0x45
+    binary.BigEndian.PutUint16(d[2:], uint16(28 + len(payload)))
+    // SSDP multicasts are sent with a TTL of 4 by default
+    d[8] = 4
+    d[9] = 17
+    copy(d[12:16], srcIp)
+    copy(d[16:20], dstIp)
+    var sum uint32
+    for i := 0; i < 20; i += 2 {
+        sum += uint32(binary.BigEndian.Uint16(d[i:]))
+    }
+    for sum > 0xffff {
+        sum = (sum >> 16) + (sum & 0xffff)
+    }
+    binary.BigEndian.PutUint16(d[10:], ^uint16(sum))
+    binary.BigEndian.PutUint16(d[20:], uint16(srcPort))
+    binary.BigEndian.PutUint16(d[22:], uint16(dstPort))
+    binary.BigEndian.PutUint16(d[24:], uint16(8 + len(payload)))
+    return append(d, payload...)
+}
+
+func udpv4(a *net.UDPAddr) (net.IP, int) {
+    if a == nil || a.IP.To4() == nil {
+        return net.IPv4zero.To4(), 0
+    }
+    return a.IP.To4(), a.Port
+}
+
+
+// Reads the UDP datagrams of a pcap or pcapng capture, such as one written by PcapngWriter
+// or saved from Wireshark. Ethernet, Linux cooked, loopback and raw IP captures are understood.
+// Anything that is not an unfragmented IPv4 UDP datagram is skipped.
+type CaptureReader struct {
+    r                   io.Reader
+    ng                  bool
+    order               binary.ByteOrder
+    // for pcap, the link type and whether timestamps are nanoseconds
+    linkType            int
+    nanos               bool
+    // for pcapng, the interfaces of the current section
+    interfaces          []captureInterface
+}
+
+type captureInterface struct {
+    linkType            int
+    name                string
+    // the length of a timestamp tick
+    resolution          time.Duration
+}
+
+// Starts reading a capture, checking its header.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+    c := &CaptureReader{r: r}
+    var magic [4]byte
+    if _, err := io.ReadFull(r, magic[:]); err != nil {
+        return nil, ErrNotCapture
+    }
+    if binary.LittleEndian.Uint32(magic[:]) == pcapngSectionHeader {
+        c.ng = true
+        if err := c.readSectionHeader(); err != nil {
+            return nil, err
+        }
+        return c, nil
+    }
+    for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+        switch order.Uint32(magic[:]) {
+        case pcapMagicMicro:
+            c.order = order
+        case pcapMagicNano:
+            c.order = order
+            c.nanos = true
+        }
+    }
+    if c.order == nil {
+        return nil, ErrNotCapture
+    }
+    head := make([]byte, 20)
+    if _, err := io.ReadFull(r, head); err != nil {
+        return nil, ErrBadCapture
+    }
+    c.linkType = int(c.order.Uint32(head[16:]) & 0xffff)
+    return c, nil
+}
+
+// Returns the next datagram. io.EOF once the capture is done.
+// Packets in pcap files, and in pcapng files without direction flags, are inbound.
+func (c *CaptureReader) Next() (Packet, error) {
+    for {
+        var p Packet
+        var linkType int
+        var err error
+        if c.ng {
+            p, linkType, err = c.nextBlock()
+        } else {
+            p, linkType, err = c.nextRecord()
+        }
+        if err != nil {
+            return Packet{}, err
+        }
+        if linkType < 0 {
+            continue
+        }
+        if ok := decodeDatagram(&p, linkType); ok {
+            return p, nil
+        }
+    }
+}
+
+func (c *CaptureReader) nextRecord() (Packet, int, error) {
+    head := make([]byte, 16)
+    if _, err := io.ReadFull(c.r, head); err != nil {
+        if err == io.EOF {
+            return Packet{}, 0, io.EOF
+        }
+        return Packet{}, 0, ErrBadCapture
+    }
+    sec := int64(c.order.Uint32(head[0:]))
+    frac := int64(c.order.Uint32(head[4:]))
+    if !c.nanos {
+        frac *= 1000
+    }
+    length := c.order.Uint32(head[8:])
+    if length > maxCaptureBlock {
+        return Packet{}, 0, ErrBadCapture
+    }
+    data := make([]byte, length)
+    if _, err := io.ReadFull(c.r, data); err != nil {
+        return Packet{}, 0, ErrBadCapture
+    }
+    return Packet{Time: time.Unix(sec, frac), Data: data}, c.linkType, nil
+}
+
+// Reads a pcapng block. A link type of -1 means the block held no packet.
+func (c *CaptureReader) nextBlock() (Packet, int, error) {
+    head := make([]byte, 8)
+    if _, err := io.ReadFull(c.r, head); err != nil {
+        if err == io.EOF {
+            return Packet{}, 0, io.EOF
+        }
+        return Packet{}, 0, ErrBadCapture
+    }
+    if binary.LittleEndian.Uint32(head) == pcapngSectionHeader {
+        c.r = io.MultiReader(bytes.NewReader(head[4:]), c.r)
+        return Packet{}, -1, c.readSectionHeader()
+    }
+    blockType := c.order.Uint32(head[0:])
+    total := c.order.Uint32(head[4:])
+    if total < 12 || total % 4 != 0 || total > maxCaptureBlock {
+        return Packet{}, 0, ErrBadCapture
+    }
+    body := make([]byte, total - 8)
+    if _, err := io.ReadFull(c.r, body); err != nil {
+        return Packet{}, 0, ErrBadCapture
+    }
+    body = body[:len(body) - 4]
+
+    switch blockType {
+    case pcapngInterface:
+        if len(body) < 8 {
+            return Packet{}, 0, ErrBadCapture
+        }
+        iface := captureInterface{linkType: int(c.order.Uint16(body[0:])), resolution: time.Microsecond}
+        c.readOptions(body[8:], func (code uint16, value []byte) {
+            switch code {
+            case pcapngOptIfName:
+                iface.name = string(value)
+            case pcapngOptIfTsresol:
+                if len(value) > 0 {
+                    iface.resolution = tsResolution(value[0])
+                }
+            }
+        })
+        c.interfaces = append(c.interfaces, iface)
+    case pcapngEnhancedPacket:
+        if len(body) < 20 {
+            return Packet{}, 0, ErrBadCapture
+        }
+        id := c.order.Uint32(body[0:])
+        if int(id) >= len(c.interfaces) {
+            return Packet{}, 0, ErrBadCapture
+        }
+        iface := c.interfaces[id]
+        ticks := uint64(c.order.Uint32(body[4:])) << 32 | uint64(c.order.Uint32(body[8:]))
+        captured := int(c.order.Uint32(body[12:]))
+        if 20 + captured > len(body) {
+            return Packet{}, 0, ErrBadCapture
+        }
+        p := Packet{
+            Time        : time.Unix(0, 0).Add(time.Duration(ticks) * iface.resolution),
+            Interface   : iface.name,
+            Data        : body[20:20 + captured],
+        }
+        optStart := 20 + (captured + 3) / 4 * 4
+        if optStart <= len(body) {
+            c.readOptions(body[optStart:], func (code uint16, value []byte) {
+                if code == pcapngOptEpbFlags && len(value) == 4 {
+                    p.Outbound = c.order.Uint32(value) & 3 == pcapngOutbound
+                }
+            })
+        }
+        return p, iface.linkType, nil
+    case pcapngSimplePacket:
+        if len(body) < 4 || len(c.interfaces) == 0 {
+            return Packet{}, 0, ErrBadCapture
+        }
+        length := int(c.order.Uint32(body[0:]))
+        if 4 + length > len(body) {
+            length = len(body) - 4
+        }
+        return Packet{Interface: c.interfaces[0].name, Data: body[4:4 + length]}, c.interfaces[0].linkType, nil
+    }
+    return Packet{}, -1, nil
+}
+
+// Reads the rest of a section header, once its block type has been read.
+func (c *CaptureReader) readSectionHeader() error {
+    head := make([]byte, 8)
+    if _, err := io.ReadFull(c.r, head); err != nil {
+        return ErrBadCapture
+    }
+    switch {
+    case binary.LittleEndian.Uint32(head[4:]) == pcapngByteOrderMagic:
+        c.order = binary.LittleEndian
+    case binary.BigEndian.Uint32(head[4:]) == pcapngByteOrderMagic:
+        c.order = binary.BigEndian
+    default:
+        return ErrNotCapture
+    }
+    total := c.order.Uint32(head[0:])
+    if total < 28 || total % 4 != 0 {
+        return ErrBadCapture
+    }
+    if _, err := io.CopyN(io.Discard, c.r, int64(total - 12)); err != nil {
+        return ErrBadCapture
+    }
+    c.interfaces = nil
+    return nil
+}
+
+func (c *CaptureReader) readOptions(opts []byte, f func (code uint16, value []byte)) {
+    for len(opts) >= 4 {
+        code := c.order.Uint16(opts[0:])
+        length := int(c.order.Uint16(opts[2:]))
+        if code == pcapngOptEnd || 4 + length > len(opts) {
+            return
+        }
+        f(code, opts[4:4 + length])
+        opts = opts[4 + (length + 3) / 4 * 4:]
+    }
+}
+
+func tsResolution(v byte) time.Duration {
+    d := float64(time.Second)
+    for i := 0; i < int(v & 0x7f); i++ {
+        if v & 0x80 != 0 {
+            d /= 2
+        } else {
+            d /= 10
+        }
+    }
+    if d < 1 {
+        return 1
+    }
+    return time.Duration(d)
+}
+
+// Strips the link layer, IPv4 and UDP headers of p.Data, filling in its addresses.
+func decodeDatagram(p *Packet, linkType int) bool {
+    data := p.Data
+    switch linkType {
+    case linkTypeRaw, linkTypeIpv4:
+    case linkTypeEthernet:
+        if len(data) < 14 {
+            return false
+        }
+        etherType := binary.BigEndian.Uint16(data[12:])
+        data = data[14:]
+        // 802.1Q VLAN tag
+        if etherType == 0x8100 && len(data) >= 4 {
+            etherType = binary.BigEndian.Uint16(data[2:])
+            data = data[4:]
+        }
+        if etherType != 0x0800 {
+            return false
+        }
+    case linkTypeLinuxSll:
+        if len(data) < 16 || binary.BigEndian.Uint16(data[14:]) != 0x0800 {
+            return false
+        }
+        data = data[16:]
+    case linkTypeLinuxSll2:
+        if len(data) < 20 || binary.BigEndian.Uint16(data[0:]) != 0x0800 {
+            return false
+        }
+        data = data[20:]
+    case linkTypeNull:
+        if len(data) < 4 {
+            return false
+        }
+        data = data[4:]
+    default:
+        return false
+    }
+
+    if len(data) < 20 || data[0] >> 4 != 4 || data[9] != 17 {
+        return false
+    }
+    headLen := int(data[0] & 0x0f) * 4
+    // a fragment, or the first part of a fragmented datagram
+    if binary.BigEndian.Uint16(data[6:]) & 0x3fff != 0 {
+        return false
+    }
+    if len(data) < headLen + 8 {
+        return false
+    }
+    udp := data[headLen:]
+    udpLen := int(binary.BigEndian.Uint16(udp[4:]))
+    if udpLen < 8 || udpLen > len(udp) {
+        return false
+    }
+    p.Source = &net.UDPAddr{IP: net.IP(append([]byte(nil), data[12:16]...)), Port: int(binary.BigEndian.Uint16(udp[0:]))}
+    p.Destination = &net.UDPAddr{IP: net.IP(append([]byte(nil), data[16:20]...)), Port: int(binary.BigEndian.Uint16(udp[2:]))}
+    p.Data = udp[8:udpLen]
+    return true
+}
@@ -0,0 +1,165 @@
+package gossdp
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "io"
+    "log/slog"
+    "net"
+    "testing"
+    "time"
+)
+
+
+const testNotify = "NOTIFY * HTTP/1.1\r\n" +
+    "HOST: 239.255.255.250:1900\r\n" +
+    "NT: upnp:rootdevice\r\n" +
+    "NTS: ssdp:alive\r\n" +
+    "USN: uuid:2fac1234-31f8-11b4-a222-08002b34c003::upnp:rootdevice\r\n" +
+    "LOCATION: http://192.168.1.20:8080/description.xml\r\n" +
+    "CACHE-CONTROL: max-age=100\r\n" +
+    "SERVER: Linux/1 UPnP/1.1 test/1\r\n" +
+    "\r\n"
+
+func testCapture(t *testing.T, packets []Packet) []byte {
+    var buf bytes.Buffer
+    w, err := NewPcapngWriter(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, p := range packets {
+        w.CapturePacket(p)
+    }
+    if err := w.Err(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestPcapngRoundTrip(t *testing.T) {
+    device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 1900}
+    group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+    searcher := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 30), Port: 50000}
+    start := time.Unix(1700000000, 123456000)
+    packets := []Packet{
+        {Time: start, Interface: "eth0", Source: device, Destination: group, Data: []byte(testNotify)},
+        {Time: start.Add(time.Second), Outbound: true, Source: device, Destination: searcher, Data: []byte("HTTP/1.1 200 OK\r\n\r\n")},
+        {Time: start.Add(2 * time.Second), Interface: "wlan0", Source: searcher, Destination: group, Data: []byte{}},
+    }
+
+    r, err := NewCaptureReader(bytes.NewReader(testCapture(t, packets)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, want := range packets {
+        got, err := r.Next()
+        if err != nil {
+            t.Fatalf("packet %d: %v", i, err)
+        }
+        if !got.Time.Equal(want.Time) {
+            t.Errorf("packet %d: time %v, want %v", i, got.Time, want.Time)
+        }
+        if got.Outbound != want.Outbound {
+            t.Errorf("packet %d: outbound %v, want %v", i, got.Outbound, want.Outbound)
+        }
+        if got.Interface != want.Interface {
+            t.Errorf("packet %d: interface %q, want %q", i, got.Interface, want.Interface)
+        }
+        if got.Source.String() != want.Source.String() || got.Destination.String() != want.Destination.String() {
+            t.Errorf("packet %d: %v -> %v, want %v -> %v", i, got.Source, got.Destination, want.Source, want.Destination)
+        }
+        if !bytes.Equal(got.Data, want.Data) {
+            t.Errorf("packet %d: data %q, want %q", i, got.Data, want.Data)
+        }
+    }
+    if _, err := r.Next(); err != io.EOF {
+        t.Errorf("after the last packet: %v, want io.EOF", err)
+    }
+}
+
+func TestPcapngOversizedBlock(t *testing.T) {
+    capture := testCapture(t, nil)
+    // a block claiming 4 GiB, which must not be allocated
+    block := make([]byte, 8)
+    binary.LittleEndian.PutUint32(block[0:], pcapngEnhancedPacket)
+    binary.LittleEndian.PutUint32(block[4:], 0xFFFFFFFC)
+    capture = append(capture, block...)
+
+    r, err := NewCaptureReader(bytes.NewReader(capture))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := r.Next(); !errors.Is(err, ErrBadCapture) {
+        t.Errorf("got %v, want ErrBadCapture", err)
+    }
+}
+
+func TestPcapOversizedRecord(t *testing.T) {
+    capture := make([]byte, 24 + 16)
+    binary.LittleEndian.PutUint32(capture[0:], pcapMagicMicro)
+    binary.LittleEndian.PutUint32(capture[20:], linkTypeRaw)
+    binary.LittleEndian.PutUint32(capture[32:], 0xFFFFFFFF)
+
+    r, err := NewCaptureReader(bytes.NewReader(capture))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := r.Next(); !errors.Is(err, ErrBadCapture) {
+        t.Errorf("got %v, want ErrBadCapture", err)
+    }
+}
+
+type aliveRecorder struct {
+    alive               []AliveMessage
+}
+
+func (a *aliveRecorder) NotifyAlive(m AliveMessage) {
+    a.alive = append(a.alive, m)
+}
+
+func (a *aliveRecorder) NotifyBye(m ByeMessage) {
+}
+
+func (a *aliveRecorder) Response(m ResponseMessage) {
+}
+
+func TestReplayCapture(t *testing.T) {
+    device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 1900}
+    group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+    capture := testCapture(t, []Packet{
+        {Time: time.Now(), Source: device, Destination: group, Data: []byte(testNotify)},
+        // what we sent is not replayed
+        {Time: time.Now(), Outbound: true, Source: device, Destination: group, Data: []byte(testNotify)},
+    })
+
+    l := &aliveRecorder{}
+    s, err := NewSsdpReplay(l, bytes.NewReader(capture), slog.Default())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := s.ListenFor("upnp:rootdevice"); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Run(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(l.alive) != 1 {
+        t.Fatalf("got %d ssdp:alive, want 1", len(l.alive))
+    }
+    m := l.alive[0]
+    if m.Usn.Uuid != "2fac1234-31f8-11b4-a222-08002b34c003" {
+        t.Errorf("uuid %q", m.Usn.Uuid)
+    }
+    if m.DeviceId != "2fac1234-31f8-11b4-a222-08002b34c003" || m.Urn != "upnp:rootdevice" {
+        t.Errorf("deprecated DeviceId %q, Urn %q", m.DeviceId, m.Urn)
+    }
+    if m.Location != "http://192.168.1.20:8080/description.xml" {
+        t.Errorf("location %q", m.Location)
+    }
+    if m.MaxAge != 100 {
+        t.Errorf("max-age %d, want 100", m.MaxAge)
+    }
+}
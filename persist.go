@@ -0,0 +1,195 @@
+package gossdp
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+
+// The version of the snapshot format written by Save.
+const snapshotVersion = 1
+
+// What VerifyRestored compares a restored device against.
+type restoredDevice struct {
+    lastSeen            time.Time
+    bootId              int
+}
+
+// What Save writes.
+type registrySnapshot struct {
+    Version             int                 `json:"version"`
+    Saved               time.Time           `json:"saved"`
+    Devices             []RegisteredDevice  `json:"devices"`
+}
+
+// Writes the devices still on the network as JSON, with when each service expires.
+// Devices that said ssdp:byebye or expired are left out.
+func (r *Registry) Save(w io.Writer) error {
+    r.lock.Lock()
+    now := r.now()
+    snap := registrySnapshot{Version: snapshotVersion, Saved: now, Devices: []RegisteredDevice{}}
+    for _, d := range r.devices {
+        if d.Gone(now) {
+            continue
+        }
+        snap.Devices = append(snap.Devices, d.copy())
+    }
+    r.lock.Unlock()
+
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(snap)
+}
+
+// Restores the devices written by Save, dropping the services that expired since.
+// Devices the registry already knows are kept as they are. Returns how many were restored.
+// See VerifyRestored to check they are still there.
+func (r *Registry) Load(rd io.Reader) (int, error) {
+    var snap registrySnapshot
+    if err := json.NewDecoder(rd).Decode(&snap); err != nil {
+        return 0, fmt.Errorf("Bad registry snapshot: %w", err)
+    }
+    if snap.Version != snapshotVersion {
+        return 0, fmt.Errorf("Unsupported registry snapshot version %d", snap.Version)
+    }
+
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    now := r.now()
+    restored := 0
+    for i := range snap.Devices {
+        d := snap.Devices[i]
+        uuid := strings.ToLower(d.Uuid)
+        if _, ok := r.devices[uuid]; ok || uuid == "" || !d.ByeAt.IsZero() {
+            continue
+        }
+        d.pruneServices(now)
+        d.updateExpires()
+        if len(d.Services) == 0 {
+            continue
+        }
+        r.devices[uuid] = &d
+        r.restored[uuid] = restoredDevice{lastSeen: d.LastSeen, bootId: d.BootId}
+        restored++
+    }
+    return restored, nil
+}
+
+// Saves to a file, replacing it in one step so a crash never leaves half a snapshot.
+func (r *Registry) SaveFile(path string) error {
+    f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) + ".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(f.Name())
+    if err := r.Save(f); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(f.Name(), path)
+}
+
+// Loads a file written by SaveFile. A missing file restores nothing.
+func (r *Registry) LoadFile(path string) (int, error) {
+    f, err := os.Open(path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    defer f.Close()
+    return r.Load(f)
+}
+
+// Saves to path every interval until ctx is done, then once more.
+// Returns the first error saving.
+func (r *Registry) SaveEvery(ctx context.Context, path string, interval time.Duration) error {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <- ctx.Done():
+            return r.SaveFile(path)
+        case <- ticker.C:
+            if err := r.SaveFile(path); err != nil {
+                return err
+            }
+        }
+    }
+}
+
+// Checks the devices Load restored are still there, by sending a unicast ssdp:all
+// M-SEARCH to each address they were heard from and waiting for the answers. A device
+// answers for every device, embedded one and service it has, so those that answer with
+// a new BOOTID.UPNP.ORG rebooted, and the services they did not answer for are dropped.
+// Devices not heard from are kept until they expire as saved: UPnP 1.0 devices need not
+// answer unicast searches, and a byebye still removes them.
+// The registry must be attached to c, and c running.
+func (r *Registry) VerifyRestored(ctx context.Context, c *ClientSsdp, wait time.Duration) error {
+    r.lock.Lock()
+    restored := r.restored
+    r.restored = make(map[string]restoredDevice)
+    // one search per address, as it is answered for every device there
+    ips := make(map[string]bool)
+    for uuid := range restored {
+        if d, ok := r.devices[uuid]; ok {
+            for _, ip := range d.Sources {
+                ips[ip] = true
+            }
+        }
+    }
+    r.lock.Unlock()
+    if len(restored) == 0 {
+        return nil
+    }
+
+    for ip := range ips {
+        if err := c.SearchUnicast("ssdp:all", ip); err != nil {
+            return err
+        }
+    }
+    timer := time.NewTimer(wait)
+    defer timer.Stop()
+    select {
+    case <- ctx.Done():
+        return ctx.Err()
+    case <- timer.C:
+    }
+
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    for uuid, was := range restored {
+        d, ok := r.devices[uuid]
+        if !ok || !d.LastSeen.After(was.lastSeen) {
+            continue
+        }
+        if was.bootId >= 0 && d.BootId >= 0 && d.BootId != was.bootId {
+            d.dropServicesSince(was.lastSeen)
+        }
+    }
+    return nil
+}
+
+// Drops the services not heard from after since.
+func (d *RegisteredDevice) dropServicesSince(since time.Time) {
+    kept := d.Services[:0]
+    for _, svc := range d.Services {
+        if svc.LastSeen.After(since) {
+            kept = append(kept, svc)
+        }
+    }
+    d.Services = kept
+    d.updateExpires()
+}
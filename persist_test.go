@@ -0,0 +1,199 @@
+package gossdp
+
+import (
+    "bytes"
+    "context"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+
+func testResponse(uuid string, st SearchTarget, ip string, maxAge, bootId int) Event {
+    return Event{
+        Type                : EventResponse,
+        Time                : time.Now(),
+        Source              : ip + ":1900",
+        Response            : &ResponseMessage{
+            SearchType      : st,
+            Usn             : NewUSN(uuid, st),
+            Location        : "http://" + ip + "/description.xml",
+            MaxAge          : maxAge,
+            BootId          : bootId,
+            ConfigId        : -1,
+            RawResponse     : &http.Response{Header: http.Header{}},
+        },
+    }
+}
+
+func testBye(uuid string, st SearchTarget, ip string) Event {
+    return Event{
+        Type                : EventBye,
+        Time                : time.Now(),
+        Source              : ip + ":1900",
+        Bye                 : &ByeMessage{
+            SearchType      : st,
+            Usn             : NewUSN(uuid, st),
+            BootId          : -1,
+            ConfigId        : -1,
+            RawRequest      : &http.Request{Header: http.Header{}},
+        },
+    }
+}
+
+// A registry whose devices were heard a minute ago.
+func savedRegistry(t *testing.T, events ...Event) *bytes.Buffer {
+    r := NewRegistry()
+    heard := time.Now().Add(-time.Minute)
+    r.now = func () time.Time { return heard }
+    for _, ev := range events {
+        r.Handle(ev)
+    }
+    var snap bytes.Buffer
+    if err := r.Save(&snap); err != nil {
+        t.Fatal(err)
+    }
+    return &snap
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+    const (
+        kept    = "2fac1234-31f8-11b4-a222-08002b34c003"
+        left    = "3fac1234-31f8-11b4-a222-08002b34c003"
+    )
+    root := SearchTarget{Kind: TargetRootDevice}
+    snap := savedRegistry(t,
+        testResponse(kept, root, "192.168.1.20", 1800, 3),
+        // expired since it was saved
+        testResponse(kept, DeviceTarget("schemas-upnp-org", "MediaServer", 1), "192.168.1.20", 30, 3),
+        testResponse(left, root, "192.168.1.21", 1800, 3),
+        testBye(left, root, "192.168.1.21"),
+    )
+
+    r := NewRegistry()
+    n, err := r.Load(bytes.NewReader(snap.Bytes()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 1 {
+        t.Fatalf("restored %d devices, want 1", n)
+    }
+    d, ok := r.Device(kept)
+    if !ok {
+        t.Fatal("the device was not restored")
+    }
+    if len(d.Services) != 1 || d.Services[0].SearchType != root {
+        t.Errorf("restored services %+v, want only the root device", d.Services)
+    }
+    if d.Location != "http://192.168.1.20/description.xml" || d.BootId != 3 || len(d.Sources) != 1 || d.Sources[0] != "192.168.1.20" {
+        t.Errorf("restored %+v", d)
+    }
+    if _, ok := r.Device(left); ok {
+        t.Error("a device that said byebye was restored")
+    }
+
+    // what the registry already knows is not replaced
+    r = NewRegistry()
+    r.Handle(testResponse(kept, root, "192.168.1.30", 1800, 4))
+    if n, err := r.Load(bytes.NewReader(snap.Bytes())); err != nil || n != 0 {
+        t.Errorf("Load over a known device: %d, %v", n, err)
+    }
+    if d, _ := r.Device(kept); d.BootId != 4 {
+        t.Errorf("the known device was replaced: %+v", d)
+    }
+}
+
+func TestRegistrySnapshotVersion(t *testing.T) {
+    for _, snap := range []string{
+        `{"version": 2, "devices": []}`,
+        `{"devices": []}`,
+        `not json`,
+    } {
+        if _, err := NewRegistry().Load(strings.NewReader(snap)); err == nil {
+            t.Errorf("%s: loaded", snap)
+        }
+    }
+}
+
+func TestVerifyRestored(t *testing.T) {
+    const (
+        rebooted    = "2fac1234-31f8-11b4-a222-08002b34c003"
+        same        = "3fac1234-31f8-11b4-a222-08002b34c003"
+        quiet       = "4fac1234-31f8-11b4-a222-08002b34c003"
+    )
+    root := SearchTarget{Kind: TargetRootDevice}
+    server := DeviceTarget("schemas-upnp-org", "MediaServer", 1)
+    directory := ServiceTarget("schemas-upnp-org", "ContentDirectory", 1)
+    snap := savedRegistry(t,
+        testResponse(rebooted, root, "192.168.1.20", 1800, 1),
+        testResponse(rebooted, server, "192.168.1.20", 1800, 1),
+        testResponse(rebooted, directory, "192.168.1.20", 1800, 1),
+        testResponse(same, root, "192.168.1.21", 1800, 5),
+        testResponse(same, server, "192.168.1.21", 1800, 5),
+        testResponse(quiet, root, "192.168.1.22", 1800, 1),
+    )
+    r := NewRegistry()
+    if _, err := r.Load(snap); err != nil {
+        t.Fatal(err)
+    }
+
+    // queues its searches rather than sending them
+    c := &ClientSsdp{isRunning: true, writeChannel: make(chan writeMessage, 8)}
+    done := make(chan error, 1)
+    go func () {
+        done <- r.VerifyRestored(context.Background(), c, 200 * time.Millisecond)
+    }()
+    // the rebooted device no longer has its ContentDirectory
+    r.Handle(testResponse(rebooted, root, "192.168.1.20", 1800, 2))
+    r.Handle(testResponse(rebooted, server, "192.168.1.20", 1800, 2))
+    r.Handle(testResponse(same, root, "192.168.1.21", 1800, 5))
+    if err := <- done; err != nil {
+        t.Fatal(err)
+    }
+
+    sent := map[string]bool{}
+    for len(c.writeChannel) > 0 {
+        msg := <- c.writeChannel
+        if st := messageHeader(msg.message, "ST"); st != "ssdp:all" {
+            t.Errorf("searched for %s, want ssdp:all", st)
+        }
+        sent[msg.to.IP.String()] = true
+    }
+    if len(sent) != 3 || !sent["192.168.1.20"] || !sent["192.168.1.21"] || !sent["192.168.1.22"] {
+        t.Errorf("searched %v, want each address once", sent)
+    }
+
+    tests := []struct {
+        uuid        string
+        want        []SearchTarget
+    }{
+        {rebooted, []SearchTarget{root, server}},
+        {same, []SearchTarget{root, server}},
+        {quiet, []SearchTarget{root}},
+    }
+    for _, tt := range tests {
+        d, ok := r.Device(tt.uuid)
+        if !ok {
+            t.Errorf("%s: dropped", tt.uuid)
+            continue
+        }
+        var got []SearchTarget
+        for _, svc := range d.Services {
+            got = append(got, svc.SearchType)
+        }
+        if len(got) != len(tt.want) {
+            t.Errorf("%s: services %v, want %v", tt.uuid, got, tt.want)
+            continue
+        }
+        for _, st := range tt.want {
+            found := false
+            for _, g := range got {
+                found = found || g == st
+            }
+            if !found {
+                t.Errorf("%s: services %v, want %v", tt.uuid, got, tt.want)
+            }
+        }
+    }
+}
@@ -0,0 +1,186 @@
+/*
+Counts SSDP traffic and serves it in the Prometheus text exposition format,
+without depending on the Prometheus client library.
+
+    m := prometheus.New()
+    s.SetMetrics(m)
+    http.Handle("/metrics", m)
+
+It also turns discovered devices into scrape targets. See ServiceDiscovery.
+
+    r := gossdp.NewRegistry()
+    r.Attach(s)
+    http.Handle("/sd", prometheus.NewServiceDiscovery(r))
+*/
+package prometheus
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// The upper bounds of the response latency histogram, in seconds.
+// Responses to multicast searches wait a random time within MX, up to 5 seconds.
+var LatencyBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type packetLabels struct {
+    method              string
+    nts                 string
+}
+
+// A gossdp.Metrics that keeps counts in memory. It is an http.Handler serving them.
+// Give one to a single Ssdp or ClientSsdp, or share it to count them together.
+type Metrics struct {
+    lock                sync.Mutex
+    packetsIn           map[packetLabels]uint64
+    packetsOut          map[packetLabels]uint64
+    parseFailures       uint64
+    responsesSent       uint64
+    searchesLimited     uint64
+    writeErrors         uint64
+    registrySize        int
+    latencyCounts       []uint64
+    latencySum          float64
+    latencyCount        uint64
+}
+
+var _ gossdp.Metrics = (*Metrics)(nil)
+
+func New() *Metrics {
+    return &Metrics{
+        packetsIn           : make(map[packetLabels]uint64),
+        packetsOut          : make(map[packetLabels]uint64),
+        latencyCounts       : make([]uint64, len(LatencyBuckets)),
+    }
+}
+
+func (m *Metrics) PacketIn(method, nts string) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.packetsIn[packetLabels{method, nts}]++
+}
+
+func (m *Metrics) PacketOut(method, nts string) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.packetsOut[packetLabels{method, nts}]++
+}
+
+func (m *Metrics) ParseFailure() {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.parseFailures++
+}
+
+func (m *Metrics) ResponseSent() {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.responsesSent++
+}
+
+func (m *Metrics) SearchRateLimited() {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.searchesLimited++
+}
+
+func (m *Metrics) WriteError() {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.writeErrors++
+}
+
+func (m *Metrics) RegistrySize(n int) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    m.registrySize = n
+}
+
+func (m *Metrics) ResponseLatency(d time.Duration) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    seconds := d.Seconds()
+    for i, le := range LatencyBuckets {
+        if seconds <= le {
+            m.latencyCounts[i]++
+        }
+    }
+    m.latencySum += seconds
+    m.latencyCount++
+}
+
+// Writes every metric in the text exposition format.
+// The metrics are rendered first, so a slow reader never holds up the counters.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+    var buf bytes.Buffer
+    m.render(&buf)
+    return buf.WriteTo(w)
+}
+
+func (m *Metrics) render(w *bytes.Buffer) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    writePackets(w, "gossdp_packets_in_total", "SSDP messages read, by method and NTS.", m.packetsIn)
+    writePackets(w, "gossdp_packets_out_total", "SSDP messages written, by method and NTS.", m.packetsOut)
+    writeSingle(w, "gossdp_parse_failures_total", "Datagrams that were not valid SSDP messages.", "counter", float64(m.parseFailures))
+    writeSingle(w, "gossdp_responses_sent_total", "Responses written to M-SEARCH requests.", "counter", float64(m.responsesSent))
+    writeSingle(w, "gossdp_searches_rate_limited_total", "M-SEARCH requests ignored for exceeding the rate limit.", "counter", float64(m.searchesLimited))
+    writeSingle(w, "gossdp_write_errors_total", "Messages that failed to send.", "counter", float64(m.writeErrors))
+    writeSingle(w, "gossdp_registry_size", "Services being advertised.", "gauge", float64(m.registrySize))
+
+    name := "gossdp_response_latency_seconds"
+    fmt.Fprintf(w, "# HELP %s Time from reading an M-SEARCH to writing the response.\n", name)
+    fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+    for i, le := range LatencyBuckets {
+        fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, m.latencyCounts[i])
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.latencyCount)
+    fmt.Fprintf(w, "%s_sum %g\n", name, m.latencySum)
+    fmt.Fprintf(w, "%s_count %d\n", name, m.latencyCount)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    m.WriteTo(w)
+}
+
+
+func writeSingle(w io.Writer, name, help, kind string, v float64) {
+    fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+    fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+    fmt.Fprintf(w, "%s %g\n", name, v)
+}
+
+func writePackets(w io.Writer, name, help string, counts map[packetLabels]uint64) {
+    fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+    fmt.Fprintf(w, "# TYPE %s counter\n", name)
+    labels := make([]packetLabels, 0, len(counts))
+    for l := range counts {
+        labels = append(labels, l)
+    }
+    sort.Slice(labels, func (i, j int) bool {
+        if labels[i].method != labels[j].method {
+            return labels[i].method < labels[j].method
+        }
+        return labels[i].nts < labels[j].nts
+    })
+    for _, l := range labels {
+        fmt.Fprintf(w, "%s{method=\"%s\",nts=\"%s\"} %d\n", name, escapeLabel(l.method), escapeLabel(l.nts), counts[l])
+    }
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+    return labelEscaper.Replace(v)
+}
@@ -0,0 +1,174 @@
+package prometheus
+
+import (
+    "encoding/json"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+// Serves the devices in a registry as Prometheus HTTP service discovery targets.
+// Point an http_sd_config at it:
+//
+//      scrape_configs:
+//        - job_name: upnp
+//          http_sd_configs:
+//            - url: http://localhost:9100/sd?st=urn:schemas-upnp-org:device:InternetGatewayDevice:1
+//
+// Each matching USN is a target group. The target is the host:port of its LOCATION,
+// or the host with the port given to SetPort. USNs of one device with the same target,
+// as ssdp:all or uuid: give, are one group that has only the device level labels. Labels:
+//
+//      __meta_ssdp_usn         the USN, when the group has one
+//      __meta_ssdp_st          its search target, when the group has one
+//      __meta_ssdp_uuid        the device UUID
+//      __meta_ssdp_server      SERVER
+//      __meta_ssdp_location    LOCATION
+//      __meta_ssdp_path        the path of LOCATION
+//
+// Only USNs matching one of the search targets are listed, as an M-SEARCH for them
+// would match. They are those given to SetSearchTargets and any st query parameters.
+// With neither, each device is listed once by its upnp:rootdevice USN.
+type ServiceDiscovery struct {
+    registry            *gossdp.Registry
+    lock                sync.Mutex
+    targets             []gossdp.SearchTarget
+    port                int
+}
+
+// A target group of the http_sd_config format.
+type targetGroup struct {
+    Targets             []string            `json:"targets"`
+    Labels              map[string]string   `json:"labels"`
+}
+
+func NewServiceDiscovery(r *gossdp.Registry) *ServiceDiscovery {
+    return &ServiceDiscovery{registry: r}
+}
+
+// Only lists USNs matching one of the search targets.
+func (sd *ServiceDiscovery) SetSearchTargets(sts []string) error {
+    var targets []gossdp.SearchTarget
+    for _, st := range sts {
+        t, err := gossdp.ParseSearchTarget(st)
+        if err != nil {
+            return err
+        }
+        targets = append(targets, t)
+    }
+    sd.lock.Lock()
+    defer sd.lock.Unlock()
+    sd.targets = targets
+    return nil
+}
+
+// Scrapes the LOCATION host on port, rather than the port of LOCATION.
+// Exporters rarely share a port with the device description. 0 keeps the LOCATION port.
+func (sd *ServiceDiscovery) SetPort(port int) {
+    sd.lock.Lock()
+    defer sd.lock.Unlock()
+    sd.port = port
+}
+
+func (sd *ServiceDiscovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    sd.lock.Lock()
+    targets := append([]gossdp.SearchTarget(nil), sd.targets...)
+    port := sd.port
+    sd.lock.Unlock()
+    for _, st := range r.URL.Query()["st"] {
+        t, err := gossdp.ParseSearchTarget(st)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        targets = append(targets, t)
+    }
+    if len(targets) == 0 {
+        targets = []gossdp.SearchTarget{{Kind: gossdp.TargetRootDevice}}
+    }
+
+    groups := []targetGroup{}
+    now := time.Now()
+    for _, d := range sd.registry.Devices() {
+        if d.Gone(now) {
+            // lingering
+            continue
+        }
+        // one group per target of the device
+        byTarget := make(map[string]int)
+        for _, svc := range d.Services {
+            if !wanted(targets, svc) {
+                continue
+            }
+            g, ok := serviceGroup(d, svc, port)
+            if !ok {
+                continue
+            }
+            if i, seen := byTarget[g.Targets[0]]; seen {
+                delete(groups[i].Labels, "__meta_ssdp_usn")
+                delete(groups[i].Labels, "__meta_ssdp_st")
+                continue
+            }
+            byTarget[g.Targets[0]] = len(groups)
+            groups = append(groups, g)
+        }
+    }
+    sort.SliceStable(groups, func (i, j int) bool {
+        return groups[i].Targets[0] < groups[j].Targets[0]
+    })
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(groups)
+}
+
+func wanted(targets []gossdp.SearchTarget, svc gossdp.RegisteredService) bool {
+    for _, st := range targets {
+        if st.Kind == gossdp.TargetRootDevice {
+            if svc.SearchType.Kind == gossdp.TargetRootDevice {
+                return true
+            }
+            continue
+        }
+        if _, ok := gossdp.DefaultSearchMatcher.MatchSearch(st, gossdp.AdvertisableServer{
+            ServiceType         : svc.SearchType.String(),
+            DeviceUuid          : svc.Usn.Uuid,
+        }); ok {
+            return true
+        }
+    }
+    return false
+}
+
+func serviceGroup(d gossdp.RegisteredDevice, svc gossdp.RegisteredService, port int) (targetGroup, bool) {
+    u, err := url.Parse(svc.Location)
+    if err != nil || u.Hostname() == "" {
+        return targetGroup{}, false
+    }
+    if port == 0 {
+        port, err = strconv.Atoi(u.Port())
+        if err != nil {
+            port = 80
+            if strings.EqualFold(u.Scheme, "https") {
+                port = 443
+            }
+        }
+    }
+    return targetGroup{
+        Targets             : []string{net.JoinHostPort(u.Hostname(), strconv.Itoa(port))},
+        Labels              : map[string]string{
+            "__meta_ssdp_usn"       : svc.Usn.String(),
+            "__meta_ssdp_st"        : svc.SearchType.String(),
+            "__meta_ssdp_uuid"      : d.Uuid,
+            "__meta_ssdp_server"    : svc.Server,
+            "__meta_ssdp_location"  : svc.Location,
+            "__meta_ssdp_path"      : u.Path,
+        },
+    }, true
+}
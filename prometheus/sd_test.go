@@ -0,0 +1,151 @@
+package prometheus
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/fromkeith/gossdp"
+)
+
+
+const (
+    serverUuid      = "2fac1234-31f8-11b4-a222-08002b34c003"
+    secureUuid      = "3fac1234-31f8-11b4-a222-08002b34c003"
+    goneUuid        = "4fac1234-31f8-11b4-a222-08002b34c003"
+)
+
+var (
+    rootDevice      = gossdp.SearchTarget{Kind: gossdp.TargetRootDevice}
+    mediaServer     = gossdp.DeviceTarget("schemas-upnp-org", "MediaServer", 1)
+)
+
+func alive(uuid string, st gossdp.SearchTarget, location string) gossdp.Event {
+    return gossdp.Event{
+        Type                : gossdp.EventAlive,
+        Time                : time.Now(),
+        Source              : "192.168.1.20:1900",
+        Alive               : &gossdp.AliveMessage{
+            SearchType      : st,
+            Usn             : gossdp.NewUSN(uuid, st),
+            Location        : location,
+            MaxAge          : 1800,
+            Server          : "Linux/1 UPnP/1.1 test/1",
+            BootId          : -1,
+            ConfigId        : -1,
+            RawRequest      : &http.Request{Header: http.Header{}},
+        },
+    }
+}
+
+func testRegistry() *gossdp.Registry {
+    r := gossdp.NewRegistry()
+    const location = "http://192.168.1.20:8080/description.xml"
+    r.Handle(alive(serverUuid, rootDevice, location))
+    r.Handle(alive(serverUuid, gossdp.UuidTarget(serverUuid), location))
+    r.Handle(alive(serverUuid, mediaServer, location))
+    r.Handle(alive(secureUuid, rootDevice, "https://192.168.1.21/description.xml"))
+    r.Handle(alive(goneUuid, rootDevice, "http://192.168.1.22/description.xml"))
+    r.Handle(gossdp.Event{
+        Type                : gossdp.EventBye,
+        Time                : time.Now(),
+        Source              : "192.168.1.22:1900",
+        Bye                 : &gossdp.ByeMessage{
+            SearchType      : rootDevice,
+            Usn             : gossdp.NewUSN(goneUuid, rootDevice),
+            BootId          : -1,
+            ConfigId        : -1,
+            RawRequest      : &http.Request{Header: http.Header{}},
+        },
+    })
+    return r
+}
+
+func discover(t *testing.T, sd *ServiceDiscovery, query string) []targetGroup {
+    w := httptest.NewRecorder()
+    sd.ServeHTTP(w, httptest.NewRequest("GET", "/sd?" + query, nil))
+    if w.Code != http.StatusOK {
+        t.Fatalf("%s: got %d: %s", query, w.Code, w.Body)
+    }
+    var groups []targetGroup
+    if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+        t.Fatalf("%s: %v", query, err)
+    }
+    return groups
+}
+
+func TestServiceDiscovery(t *testing.T) {
+    const location = "http://192.168.1.20:8080/description.xml"
+    serverLabels := map[string]string{
+        "__meta_ssdp_uuid"      : serverUuid,
+        "__meta_ssdp_server"    : "Linux/1 UPnP/1.1 test/1",
+        "__meta_ssdp_location"  : location,
+        "__meta_ssdp_path"      : "/description.xml",
+    }
+    secureLabels := map[string]string{
+        "__meta_ssdp_usn"       : "uuid:" + secureUuid + "::upnp:rootdevice",
+        "__meta_ssdp_st"        : "upnp:rootdevice",
+        "__meta_ssdp_uuid"      : secureUuid,
+        "__meta_ssdp_server"    : "Linux/1 UPnP/1.1 test/1",
+        "__meta_ssdp_location"  : "https://192.168.1.21/description.xml",
+        "__meta_ssdp_path"      : "/description.xml",
+    }
+    with := func (labels map[string]string, st gossdp.SearchTarget, uuid string) map[string]string {
+        copied := map[string]string{
+            "__meta_ssdp_usn"   : gossdp.NewUSN(uuid, st).String(),
+            "__meta_ssdp_st"    : st.String(),
+        }
+        for k, v := range labels {
+            copied[k] = v
+        }
+        return copied
+    }
+    tests := []struct {
+        name        string
+        port        int
+        query       string
+        want        []targetGroup
+    }{
+        // the gone device is left out, and https defaults to 443
+        {"root devices", 0, "", []targetGroup{
+            {[]string{"192.168.1.20:8080"}, with(serverLabels, rootDevice, serverUuid)},
+            {[]string{"192.168.1.21:443"}, secureLabels},
+        }},
+        // the three USNs of one device share a target, so are one group without usn and st
+        {"all", 0, "st=ssdp:all", []targetGroup{
+            {[]string{"192.168.1.20:8080"}, serverLabels},
+            {[]string{"192.168.1.21:443"}, secureLabels},
+        }},
+        {"device type", 0, "st=" + url.QueryEscape(mediaServer.String()), []targetGroup{
+            {[]string{"192.168.1.20:8080"}, with(serverLabels, mediaServer, serverUuid)},
+        }},
+        {"port", 9100, "", []targetGroup{
+            {[]string{"192.168.1.20:9100"}, with(serverLabels, rootDevice, serverUuid)},
+            {[]string{"192.168.1.21:9100"}, secureLabels},
+        }},
+    }
+    for _, tt := range tests {
+        sd := NewServiceDiscovery(testRegistry())
+        sd.SetPort(tt.port)
+        got := discover(t, sd, tt.query)
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestServiceDiscoveryBadTarget(t *testing.T) {
+    sd := NewServiceDiscovery(testRegistry())
+    w := httptest.NewRecorder()
+    sd.ServeHTTP(w, httptest.NewRequest("GET", "/sd?st=urn:schemas-upnp-org:device:MediaServer", nil))
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("got %d, want 400", w.Code)
+    }
+    if err := sd.SetSearchTargets([]string{""}); err == nil {
+        t.Error("SetSearchTargets took an empty target")
+    }
+}
@@ -0,0 +1,88 @@
+package gossdp
+
+import (
+    "net"
+    "sync"
+    "time"
+)
+
+
+// A token bucket per source address, limiting how many M-SEARCH requests we answer.
+type searchLimiter struct {
+    lock                sync.Mutex
+    // searches per second. 0 for no limit
+    rate                float64
+    burst               float64
+    buckets             map[string]*searchBucket
+    lastPrune           time.Time
+}
+
+type searchBucket struct {
+    tokens              float64
+    last                time.Time
+}
+
+// Limits how many M-SEARCH requests are answered from each IP address, to rate per
+// second with bursts of up to burst. Searches over the limit are ignored and counted
+// by Metrics.SearchRateLimited. A rate of 0 or less, the default, answers everything.
+func (s *Ssdp) SetSearchRateLimit(rate float64, burst int) {
+    s.searchLimiter.configure(rate, burst)
+}
+
+func (l *searchLimiter) configure(rate float64, burst int) {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    if rate < 0 {
+        rate = 0
+    }
+    if burst < 1 {
+        burst = 1
+    }
+    l.rate = rate
+    l.burst = float64(burst)
+    l.buckets = make(map[string]*searchBucket)
+}
+
+// True if a search from hostPort may be answered.
+func (l *searchLimiter) allow(hostPort string, now time.Time) bool {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    if l.rate == 0 {
+        return true
+    }
+    ip, _, err := net.SplitHostPort(hostPort)
+    if err != nil {
+        ip = hostPort
+    }
+    l.prune(now)
+
+    b, ok := l.buckets[ip]
+    if !ok {
+        b = &searchBucket{tokens: l.burst, last: now}
+        l.buckets[ip] = b
+    }
+    b.tokens += now.Sub(b.last).Seconds() * l.rate
+    if b.tokens > l.burst {
+        b.tokens = l.burst
+    }
+    b.last = now
+    if b.tokens < 1 {
+        return false
+    }
+    b.tokens--
+    return true
+}
+
+// Forgets sources whose buckets have refilled, so the map does not grow forever.
+func (l *searchLimiter) prune(now time.Time) {
+    full := time.Duration(l.burst / l.rate * float64(time.Second))
+    if now.Sub(l.lastPrune) < full {
+        return
+    }
+    l.lastPrune = now
+    for ip, b := range l.buckets {
+        if now.Sub(b.last) >= full {
+            delete(l.buckets, ip)
+        }
+    }
+}
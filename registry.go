@@ -0,0 +1,305 @@
+package gossdp
+
+import (
+    "net"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+
+// Keeps track of the devices on the network, from the NOTIFYs and responses it is fed.
+// Devices are dropped once their max-age runs out, or when they say ssdp:byebye.
+// See SetLinger to keep showing them for a while after.
+//
+//      r := gossdp.NewRegistry()
+//      r.Attach(s)
+//      go s.Run(ctx)
+//      ...
+//      devices := r.Devices()
+type Registry struct {
+    lock                sync.Mutex
+    devices             map[string]*RegisteredDevice
+    linger              time.Duration
+    // devices Load restored, as they were then. See VerifyRestored
+    restored            map[string]restoredDevice
+    // for tests
+    now                 func() time.Time
+}
+
+// A device the registry knows of.
+type RegisteredDevice struct {
+    // The device UUID, without uuid:
+    Uuid                string              `json:"uuid"`
+    // Each USN the device advertised, ordered by USN
+    Services            []RegisteredService `json:"services"`
+    // The IP addresses the device was heard from, sorted
+    Sources             []string            `json:"sources"`
+    // The most recent LOCATION and SERVER of any of its USNs
+    Location            string              `json:"location"`
+    Server              string              `json:"server"`
+    // BOOTID.UPNP.ORG and CONFIGID.UPNP.ORG. -1 if the device does not send them
+    BootId              int                 `json:"boot_id"`
+    ConfigId            int                 `json:"config_id"`
+    FirstSeen           time.Time           `json:"first_seen"`
+    LastSeen            time.Time           `json:"last_seen"`
+    // When the last of its USNs expires
+    Expires             time.Time           `json:"expires"`
+    // When the device said ssdp:byebye. Zero if it is still alive
+    ByeAt               time.Time           `json:"bye_at,omitzero"`
+}
+
+// One USN of a device.
+type RegisteredService struct {
+    SearchType          SearchTarget        `json:"st"`
+    Usn                 USN                 `json:"usn"`
+    Location            string              `json:"location"`
+    Server              string              `json:"server"`
+    // The max-age it was advertised with
+    MaxAge              int                 `json:"max_age"`
+    // The host:port it was last heard from
+    Source              string              `json:"source"`
+    LastSeen            time.Time           `json:"last_seen"`
+    Expires             time.Time           `json:"expires"`
+}
+
+// True once the device said ssdp:byebye, or its max-age ran out.
+func (d RegisteredDevice) Gone(now time.Time) bool {
+    return !d.ByeAt.IsZero() || !now.Before(d.Expires)
+}
+
+func NewRegistry() *Registry {
+    return &Registry{
+        devices             : make(map[string]*RegisteredDevice),
+        restored            : make(map[string]restoredDevice),
+        now                 : time.Now,
+    }
+}
+
+// Keeps devices listed for d after they say ssdp:byebye or expire, so a display can
+// show them leaving. Gone says whether they have. Defaults to 0.
+func (r *Registry) SetLinger(d time.Duration) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.linger = d
+}
+
+// Feeds the registry with everything s receives. Call the returned func to stop.
+func (r *Registry) Attach(s *Ssdp) (detach func()) {
+    return s.Subscribe(Filter{}, r.Handle)
+}
+
+// Feeds the registry with the responses to c's searches. Call the returned func to stop.
+func (r *Registry) AttachClient(c *ClientSsdp) (detach func()) {
+    return c.Subscribe(Filter{}, r.Handle)
+}
+
+// Records an event. Searches, and messages without a device UUID, are ignored.
+func (r *Registry) Handle(ev Event) {
+    usn := ev.Usn()
+    if usn.Uuid == "" {
+        return
+    }
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    now := r.now()
+    uuid := strings.ToLower(usn.Uuid)
+    d, ok := r.devices[uuid]
+
+    switch ev.Type {
+    case EventAlive, EventResponse:
+        if !ok || !d.ByeAt.IsZero() {
+            // a device coming back after a byebye starts afresh
+            d = &RegisteredDevice{Uuid: usn.Uuid, FirstSeen: now}
+            r.devices[uuid] = d
+        }
+        maxAge := ev.MaxAge()
+        if maxAge < 0 {
+            maxAge = defaultMaxAge
+        }
+        bootId, configId := ev.bootIds()
+        d.setService(RegisteredService{
+            SearchType          : ev.SearchType(),
+            Usn                 : usn,
+            Location            : ev.Location(),
+            Server              : ev.Server(),
+            MaxAge              : maxAge,
+            Source              : ev.Source,
+            LastSeen            : now,
+            Expires             : now.Add(time.Duration(maxAge) * time.Second),
+        })
+        d.Location = ev.Location()
+        d.Server = ev.Server()
+        d.BootId = bootId
+        d.ConfigId = configId
+        d.LastSeen = now
+        d.addSource(ev.Source)
+    case EventUpdate:
+        if !ok {
+            return
+        }
+        for i := range d.Services {
+            if d.Services[i].Usn.String() == usn.String() {
+                d.Services[i].Location = ev.Update.Location
+            }
+        }
+        d.Location = ev.Update.Location
+        d.BootId = ev.Update.NextBootId
+        d.ConfigId = ev.Update.ConfigId
+        d.LastSeen = now
+    case EventBye:
+        if !ok {
+            return
+        }
+        d.LastSeen = now
+        // the device level USNs going means the whole device is
+        if usn.Target.Kind == TargetRootDevice || usn.Target.Kind == TargetUuid || len(d.Services) <= 1 {
+            d.ByeAt = now
+            if r.linger == 0 {
+                delete(r.devices, uuid)
+            }
+            return
+        }
+        d.removeService(usn)
+    }
+}
+
+// The devices still on the network, and those lingering, ordered by UUID.
+func (r *Registry) Devices() []RegisteredDevice {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.prune()
+    devices := make([]RegisteredDevice, 0, len(r.devices))
+    for _, d := range r.devices {
+        devices = append(devices, d.copy())
+    }
+    sort.Slice(devices, func (i, j int) bool {
+        return devices[i].Uuid < devices[j].Uuid
+    })
+    return devices
+}
+
+// The device with the given UUID.
+func (r *Registry) Device(uuid string) (RegisteredDevice, bool) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.prune()
+    d, ok := r.devices[strings.ToLower(strings.TrimPrefix(uuid, "uuid:"))]
+    if !ok {
+        return RegisteredDevice{}, false
+    }
+    return d.copy(), true
+}
+
+// How many devices Devices would return.
+func (r *Registry) Len() int {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.prune()
+    return len(r.devices)
+}
+
+// Forgets a device.
+func (r *Registry) Remove(uuid string) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    delete(r.devices, strings.ToLower(strings.TrimPrefix(uuid, "uuid:")))
+}
+
+// Drops expired services, and devices that have been gone longer than linger. Must hold lock.
+func (r *Registry) prune() {
+    now := r.now()
+    for uuid, d := range r.devices {
+        if !d.ByeAt.IsZero() {
+            if now.Sub(d.ByeAt) >= r.linger {
+                delete(r.devices, uuid)
+            }
+            continue
+        }
+        if !now.Before(d.Expires.Add(r.linger)) {
+            delete(r.devices, uuid)
+            continue
+        }
+        // keep the services of an expired device while it lingers
+        if now.Before(d.Expires) {
+            d.pruneServices(now)
+        }
+    }
+}
+
+
+func (d *RegisteredDevice) setService(svc RegisteredService) {
+    i := sort.Search(len(d.Services), func (i int) bool {
+        return d.Services[i].Usn.String() >= svc.Usn.String()
+    })
+    if i < len(d.Services) && d.Services[i].Usn.String() == svc.Usn.String() {
+        d.Services[i] = svc
+    } else {
+        d.Services = append(d.Services, RegisteredService{})
+        copy(d.Services[i+1:], d.Services[i:])
+        d.Services[i] = svc
+    }
+    d.updateExpires()
+}
+
+func (d *RegisteredDevice) removeService(usn USN) {
+    for i := range d.Services {
+        if d.Services[i].Usn.String() == usn.String() {
+            d.Services = append(d.Services[:i], d.Services[i+1:]...)
+            break
+        }
+    }
+    d.updateExpires()
+}
+
+func (d *RegisteredDevice) pruneServices(now time.Time) {
+    kept := d.Services[:0]
+    for _, svc := range d.Services {
+        if now.Before(svc.Expires) {
+            kept = append(kept, svc)
+        }
+    }
+    d.Services = kept
+}
+
+func (d *RegisteredDevice) updateExpires() {
+    d.Expires = time.Time{}
+    for _, svc := range d.Services {
+        if svc.Expires.After(d.Expires) {
+            d.Expires = svc.Expires
+        }
+    }
+}
+
+func (d *RegisteredDevice) addSource(hostPort string) {
+    ip, _, err := net.SplitHostPort(hostPort)
+    if err != nil {
+        ip = hostPort
+    }
+    i := sort.SearchStrings(d.Sources, ip)
+    if i < len(d.Sources) && d.Sources[i] == ip {
+        return
+    }
+    d.Sources = append(d.Sources, "")
+    copy(d.Sources[i+1:], d.Sources[i:])
+    d.Sources[i] = ip
+}
+
+func (d *RegisteredDevice) copy() RegisteredDevice {
+    c := *d
+    c.Services = append([]RegisteredService(nil), d.Services...)
+    c.Sources = append([]string(nil), d.Sources...)
+    return c
+}
+
+// The BOOTID.UPNP.ORG and CONFIGID.UPNP.ORG of an alive or response.
+func (ev Event) bootIds() (int, int) {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.BootId, ev.Alive.ConfigId
+    case EventResponse:
+        return ev.Response.BootId, ev.Response.ConfigId
+    }
+    return -1, -1
+}
@@ -0,0 +1,316 @@
+package gossdp
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net"
+    "strings"
+    "time"
+)
+
+
+// The header a relay adds to what it forwards, listing the relays a message passed through.
+const relayHeader = "X-SSDP-RELAY"
+
+// How many relays a message may pass through before it is dropped, unless SetMaxHops says otherwise.
+const defaultRelayHops = 4
+
+// Returned by NewRelay on platforms that don't say which interface a packet arrived on.
+var ErrRelayUnsupported = errors.New("Relaying is not supported on this platform, as it can't tell which interface a packet arrived on")
+
+var relayTypes = []EventType{EventAlive, EventBye, EventUpdate, EventResponse, EventSearch}
+
+// Forwards SSDP between network interfaces, so devices on one subnet can be found
+// from another. NOTIFY and M-SEARCH messages multicast on one interface are multicast
+// again on the others. Responses to a forwarded search come back to the relay, which
+// sends them on to the original searcher.
+//
+// Each forwarded message carries an X-SSDP-RELAY header naming the relays it passed
+// through. A relay drops messages it already forwarded, messages that passed through
+// too many relays, and anything sent from its own addresses.
+//
+// Relaying needs to know which interface each packet arrived on, so it is only
+// supported on unix. Elsewhere NewRelay returns ErrRelayUnsupported.
+type Relay struct {
+    s                   *Ssdp
+    id                  string
+    interfaces          map[string]relayInterface
+    // searches we forwarded and are waiting for responses to.
+    //  Only used on the reader goroutine
+    searches            []relayedSearch
+    filter              Filter
+    maxHops             int
+}
+
+type relayInterface struct {
+    iface               net.Interface
+    addrs               []net.IP
+}
+
+type relayedSearch struct {
+    st                  SearchTarget
+    from                *net.UDPAddr
+    iface               string
+    expires             time.Time
+}
+
+// Creates a relay between the named interfaces. At least two are needed.
+func NewRelay(interfaces []string, lg *slog.Logger) (*Relay, error) {
+    if len(interfaces) < 2 {
+        return nil, errors.New("A relay needs at least two interfaces")
+    }
+    r := &Relay{
+        interfaces          : make(map[string]relayInterface),
+        // the zero Filter leaves out searches
+        filter              : Filter{Types: relayTypes},
+        maxHops             : defaultRelayHops,
+    }
+    var ifaces []net.Interface
+    for _, name := range interfaces {
+        iface, err := net.InterfaceByName(name)
+        if err != nil {
+            return nil, fmt.Errorf("Can't relay on %s: %w", name, err)
+        }
+        ri := relayInterface{iface: *iface}
+        addrs, _ := iface.Addrs()
+        for _, a := range addrs {
+            if n, ok := a.(*net.IPNet); ok {
+                ri.addrs = append(ri.addrs, n.IP)
+            }
+        }
+        r.interfaces[iface.Name] = ri
+        ifaces = append(ifaces, *iface)
+    }
+    id := make([]byte, 4)
+    rand.Read(id)
+    r.id = hex.EncodeToString(id)
+
+    r.s = newSsdp(nil, lg)
+    if err := r.s.createRelaySocket(ifaces); err != nil {
+        return nil, err
+    }
+    r.s.isRunning = true
+    r.s.Subscribe(Filter{Types: relayTypes}, r.relay)
+    return r, nil
+}
+
+// Only relays what passes f. A filter without Types applies to every kind of message.
+// Must be called before Run.
+func (r *Relay) SetFilter(f Filter) {
+    if len(f.Types) == 0 {
+        f.Types = relayTypes
+    }
+    r.filter = f
+}
+
+// Sets how many relays a message may pass through. Must be called before Run.
+func (r *Relay) SetMaxHops(n int) {
+    r.maxHops = n
+}
+
+// Relays until ctx is done. See Ssdp.Run.
+func (r *Relay) Run(ctx context.Context) error {
+    return r.s.Run(ctx)
+}
+
+// Calls handler with what the relay receives, as Ssdp.Subscribe does.
+// Event.Interface says where it arrived.
+func (r *Relay) Subscribe(filter Filter, handler func(Event)) (unsubscribe func()) {
+    return r.s.Subscribe(filter, handler)
+}
+
+// The name other relays see in X-SSDP-RELAY.
+func (r *Relay) Id() string {
+    return r.id
+}
+
+// Forwards one message. Runs on the reader goroutine, while s.reading is the packet.
+func (r *Relay) relay(ev Event) {
+    p := r.s.reading
+    if _, ok := r.interfaces[p.Interface]; !ok || p.Source == nil {
+        return
+    }
+    // our own multicasts loop back to us
+    if r.ownAddress(p.Source.IP) {
+        return
+    }
+    chain := relayChain(rawHeader(ev))
+    for _, id := range chain {
+        if id == r.id {
+            return
+        }
+    }
+    if len(chain) >= r.maxHops {
+        r.s.logger.Debug("Dropping message relayed too many times", logSource, ev.Source, logInterface, p.Interface)
+        return
+    }
+    if !r.filter.Matches(ev) {
+        return
+    }
+    msg := withRelayHeader(p.Data, append(chain, r.id))
+
+    switch ev.Type {
+    case EventSearch:
+        if !ev.Search.Multicast || ev.Search.Rejected != nil {
+            return
+        }
+        r.searches = expireSearches(r.searches, ev.Time)
+        wait := ev.Search.MaxWait
+        if wait > maxResponseDelay {
+            wait = maxResponseDelay
+        }
+        r.searches = append(r.searches, relayedSearch{
+            st              : ev.Search.SearchType,
+            from            : p.Source,
+            iface           : p.Interface,
+            // allow for the responses to cross the relay
+            expires         : ev.Time.Add(time.Duration(wait + 1) * time.Second),
+        })
+        r.multicast(msg, p.Interface, ev)
+    case EventResponse:
+        r.searches = expireSearches(r.searches, ev.Time)
+        for _, search := range r.searches {
+            if search.iface == p.Interface || !relayWants(search.st, ev.Response.SearchType, ev.Response.Usn) {
+                continue
+            }
+            r.s.queue(writeMessage{message: msg, to: search.from})
+            r.s.logger.Debug("Relayed response", logSource, ev.Source, logDestination, search.from.String(), logUsn, ev.Response.Usn.String())
+        }
+    default:
+        r.multicast(msg, p.Interface, ev)
+    }
+}
+
+// Sends msg out of every interface but the one it arrived on.
+func (r *Relay) multicast(msg []byte, arrivedOn string, ev Event) {
+    to := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: ssdpPort}
+    for name, ri := range r.interfaces {
+        if name == arrivedOn {
+            continue
+        }
+        r.s.queue(writeMessage{message: msg, to: to, ifIndex: ri.iface.Index})
+        r.s.logger.Debug("Relayed message", logSource, ev.Source, logInterface, name, logSt, ev.SearchType().String(), "type", ev.Type.String())
+    }
+}
+
+// Drops the searches that stopped waiting for responses.
+func expireSearches(searches []relayedSearch, now time.Time) []relayedSearch {
+    kept := searches[:0]
+    for _, search := range searches {
+        if now.Before(search.expires) {
+            kept = append(kept, search)
+        }
+    }
+    return kept
+}
+
+func (r *Relay) ownAddress(ip net.IP) bool {
+    for _, ri := range r.interfaces {
+        if ri.owns(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+func (ri relayInterface) owns(ip net.IP) bool {
+    for _, a := range ri.addrs {
+        if a.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// Queues a message unless we are shutting down.
+func (s *Ssdp) queue(msg writeMessage) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning {
+        return
+    }
+    s.writeChannel <- msg
+}
+
+
+// True if a response with the given ST and USN answers a search for st.
+func relayWants(st, responseSt SearchTarget, usn USN) bool {
+    switch st.Kind {
+    case TargetAll:
+        return true
+    case TargetRootDevice:
+        return responseSt.Kind == TargetRootDevice
+    }
+    _, ok := matchSearch(st, AdvertisableServer{ServiceType: responseSt.String(), DeviceUuid: usn.Uuid})
+    return ok
+}
+
+func rawHeader(ev Event) string {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.RawRequest.Header.Get(relayHeader)
+    case EventBye:
+        return ev.Bye.RawRequest.Header.Get(relayHeader)
+    case EventUpdate:
+        return ev.Update.RawRequest.Header.Get(relayHeader)
+    case EventResponse:
+        return ev.Response.RawResponse.Header.Get(relayHeader)
+    case EventSearch:
+        return ev.Search.RawRequest.Header.Get(relayHeader)
+    }
+    return ""
+}
+
+// The relay ids of an X-SSDP-RELAY header.
+func relayChain(header string) []string {
+    var chain []string
+    for _, id := range strings.Split(header, ",") {
+        if id = strings.TrimSpace(id); id != "" {
+            chain = append(chain, id)
+        }
+    }
+    return chain
+}
+
+// Copies msg, replacing its X-SSDP-RELAY header with one listing chain.
+func withRelayHeader(msg []byte, chain []string) []byte {
+    return withHeader(msg, relayHeader, strings.Join(chain, ","))
+}
+
+// Copies msg with the header set to value, replacing it if it was there.
+func withHeader(msg []byte, name, value string) []byte {
+    head, body, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+    lines := bytes.Split(head, []byte("\r\n"))
+    out := bytes.Buffer{}
+    out.Write(lines[0])
+    out.WriteString("\r\n")
+    for _, line := range lines[1:] {
+        k, _, _ := bytes.Cut(line, []byte(":"))
+        if strings.EqualFold(string(bytes.TrimSpace(k)), name) {
+            continue
+        }
+        out.Write(line)
+        out.WriteString("\r\n")
+    }
+    fmt.Fprintf(&out, "%s: %s\r\n\r\n", name, value)
+    out.Write(body)
+    return out.Bytes()
+}
+
+// The value of a header of a raw SSDP message. Empty if it is missing.
+func messageHeader(msg []byte, name string) string {
+    head, _, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+    lines := bytes.Split(head, []byte("\r\n"))
+    for _, line := range lines[1:] {
+        k, v, ok := bytes.Cut(line, []byte(":"))
+        if ok && strings.EqualFold(string(bytes.TrimSpace(k)), name) {
+            return string(bytes.TrimSpace(v))
+        }
+    }
+    return ""
+}
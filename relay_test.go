@@ -0,0 +1,204 @@
+package gossdp
+
+import (
+    "log/slog"
+    "net"
+    "reflect"
+    "testing"
+    "time"
+)
+
+
+func TestRelayChain(t *testing.T) {
+    tests := []struct {
+        header      string
+        want        []string
+    }{
+        {"", nil},
+        {"a1b2c3d4", []string{"a1b2c3d4"}},
+        {"a1b2c3d4, 0badf00d", []string{"a1b2c3d4", "0badf00d"}},
+        {" ,a1b2c3d4,, ", []string{"a1b2c3d4"}},
+    }
+    for _, tt := range tests {
+        if got := relayChain(tt.header); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("relayChain(%q) = %q, want %q", tt.header, got, tt.want)
+        }
+    }
+}
+
+func TestWithHeader(t *testing.T) {
+    msg := "NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nx-ssdp-relay: a1b2c3d4\r\nNTS: ssdp:alive\r\n\r\nbody\r\n\r\nmore"
+    got := string(withHeader([]byte(msg), relayHeader, "a1b2c3d4,0badf00d"))
+    want := "NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nNTS: ssdp:alive\r\nX-SSDP-RELAY: a1b2c3d4,0badf00d\r\n\r\nbody\r\n\r\nmore"
+    if got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+    if v := messageHeader([]byte(got), "x-ssdp-relay"); v != "a1b2c3d4,0badf00d" {
+        t.Errorf("messageHeader: %q", v)
+    }
+
+    got = string(withHeader([]byte("HTTP/1.1 200 OK\r\nST: ssdp:all\r\n\r\n"), relayHeader, "a1b2c3d4"))
+    if want := "HTTP/1.1 200 OK\r\nST: ssdp:all\r\nX-SSDP-RELAY: a1b2c3d4\r\n\r\n"; got != want {
+        t.Errorf("added: got %q, want %q", got, want)
+    }
+}
+
+func TestRelayWants(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    server := DeviceTarget("schemas-upnp-org", "MediaServer", 2)
+    root := SearchTarget{Kind: TargetRootDevice}
+    tests := []struct {
+        name        string
+        st          SearchTarget
+        responseSt  SearchTarget
+        want        bool
+    }{
+        {"all", SearchTarget{Kind: TargetAll}, server, true},
+        {"root device", root, root, true},
+        {"root device for a device", root, server, false},
+        {"uuid", UuidTarget(uuid), server, true},
+        {"other uuid", UuidTarget("00000000-0000-0000-0000-000000000000"), server, false},
+        {"older version", DeviceTarget("schemas-upnp-org", "MediaServer", 1), server, true},
+        {"newer version", DeviceTarget("schemas-upnp-org", "MediaServer", 3), server, false},
+        {"other type", DeviceTarget("schemas-upnp-org", "MediaRenderer", 1), server, false},
+    }
+    for _, tt := range tests {
+        if got := relayWants(tt.st, tt.responseSt, NewUSN(uuid, tt.responseSt)); got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestExpireSearches(t *testing.T) {
+    now := time.Now()
+    searches := []relayedSearch{
+        {iface: "old", expires: now.Add(-time.Second)},
+        {iface: "now", expires: now},
+        {iface: "waiting", expires: now.Add(time.Second)},
+    }
+    kept := expireSearches(searches, now)
+    if len(kept) != 1 || kept[0].iface != "waiting" {
+        t.Errorf("kept %+v", kept)
+    }
+}
+
+// A relay between eth0 and eth1 that queues what it sends, rather than sending it.
+func testRelay() *Relay {
+    r := &Relay{
+        id                  : "a1b2c3d4",
+        interfaces          : map[string]relayInterface{
+            "eth0"          : {iface: net.Interface{Index: 2, Name: "eth0"}, addrs: []net.IP{net.IPv4(192, 168, 1, 1)}},
+            "eth1"          : {iface: net.Interface{Index: 3, Name: "eth1"}, addrs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+        },
+        filter              : Filter{Types: relayTypes},
+        maxHops             : defaultRelayHops,
+    }
+    r.s = newSsdp(nil, slog.Default())
+    r.s.isRunning = true
+    r.s.Subscribe(Filter{Types: relayTypes}, r.relay)
+    return r
+}
+
+// Hands the relay a packet, as the reader goroutine does.
+func (r *Relay) testReceive(iface string, from *net.UDPAddr, msg string) {
+    r.s.reading = Packet{Time: time.Now(), Interface: iface, Source: from, Data: []byte(msg)}
+    r.s.parseMessage(msg, from.String())
+    r.s.reading = Packet{}
+}
+
+func queued(s *Ssdp) []writeMessage {
+    var sent []writeMessage
+    for {
+        select {
+        case msg := <- s.writeChannel:
+            sent = append(sent, msg)
+        default:
+            return sent
+        }
+    }
+}
+
+func TestRelayNotify(t *testing.T) {
+    device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 1900}
+    tests := []struct {
+        name        string
+        iface       string
+        from        *net.UDPAddr
+        chain       string
+        want        string
+    }{
+        {"relayed", "eth0", device, "", "a1b2c3d4"},
+        {"relayed again", "eth0", device, "0badf00d", "0badf00d,a1b2c3d4"},
+        {"below the hops", "eth0", device, "1,2,3", "1,2,3,a1b2c3d4"},
+        {"too many hops", "eth0", device, "1,2,3,4", ""},
+        {"loop", "eth0", device, "0badf00d,a1b2c3d4", ""},
+        {"own address", "eth0", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1900}, "", ""},
+        {"other interface", "wlan0", device, "", ""},
+    }
+    for _, tt := range tests {
+        r := testRelay()
+        msg := testNotify
+        if tt.chain != "" {
+            msg = string(withRelayHeader([]byte(msg), relayChain(tt.chain)))
+        }
+        r.testReceive(tt.iface, tt.from, msg)
+        sent := queued(r.s)
+        if tt.want == "" {
+            if len(sent) != 0 {
+                t.Errorf("%s: relayed %d messages, want none", tt.name, len(sent))
+            }
+            continue
+        }
+        if len(sent) != 1 {
+            t.Errorf("%s: relayed %d messages, want 1", tt.name, len(sent))
+            continue
+        }
+        if sent[0].ifIndex != 3 || sent[0].to.String() != "239.255.255.250:1900" {
+            t.Errorf("%s: sent to %v on %d, want the group on eth1", tt.name, sent[0].to, sent[0].ifIndex)
+        }
+        if got := messageHeader(sent[0].message, relayHeader); got != tt.want {
+            t.Errorf("%s: %s %q, want %q", tt.name, relayHeader, got, tt.want)
+        }
+    }
+}
+
+func TestRelaySearch(t *testing.T) {
+    r := testRelay()
+    searcher := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 30), Port: 50000}
+    r.testReceive("eth0", searcher, "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\nST: upnp:rootdevice\r\n\r\n")
+    sent := queued(r.s)
+    if len(sent) != 1 || sent[0].ifIndex != 3 {
+        t.Fatalf("search relayed as %+v, want once to eth1", sent)
+    }
+    if len(r.searches) != 1 || r.searches[0].from.String() != searcher.String() {
+        t.Fatalf("waiting for %+v", r.searches)
+    }
+
+    response := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=100\r\nEXT:\r\nLOCATION: http://10.0.0.20/description.xml\r\n" +
+        "ST: upnp:rootdevice\r\nUSN: uuid:2fac1234-31f8-11b4-a222-08002b34c003::upnp:rootdevice\r\n\r\n"
+    device := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 20), Port: 1900}
+    r.testReceive("eth1", device, response)
+    sent = queued(r.s)
+    if len(sent) != 1 || sent[0].to.String() != searcher.String() {
+        t.Fatalf("response relayed as %+v, want once to the searcher", sent)
+    }
+    if got := messageHeader(sent[0].message, relayHeader); got != "a1b2c3d4" {
+        t.Errorf("%s %q", relayHeader, got)
+    }
+
+    // responses from the searcher's side, or to something else, are not relayed
+    r.testReceive("eth0", &net.UDPAddr{IP: net.IPv4(192, 168, 1, 21), Port: 1900}, response)
+    other := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=100\r\nEXT:\r\nLOCATION: http://10.0.0.20/description.xml\r\n" +
+        "ST: urn:schemas-upnp-org:device:MediaServer:1\r\nUSN: uuid:2fac1234-31f8-11b4-a222-08002b34c003::urn:schemas-upnp-org:device:MediaServer:1\r\n\r\n"
+    r.testReceive("eth1", device, other)
+    if sent := queued(r.s); len(sent) != 0 {
+        t.Errorf("relayed %d unwanted responses", len(sent))
+    }
+
+    // nor are responses once the search stopped waiting
+    r.searches[0].expires = time.Now().Add(-time.Second)
+    r.testReceive("eth1", device, response)
+    if sent := queued(r.s); len(sent) != 0 {
+        t.Errorf("relayed %d responses after the search expired", len(sent))
+    }
+}
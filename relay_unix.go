@@ -0,0 +1,12 @@
+// +build darwin dragonfly freebsd linux nacl netbsd openbsd solaris
+
+package gossdp
+
+import (
+    "net"
+)
+
+
+func (s *Ssdp) createRelaySocket(interfaces []net.Interface) error {
+    return s.createSocketOn(interfaces)
+}
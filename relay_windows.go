@@ -0,0 +1,12 @@
+package gossdp
+
+import (
+    "fmt"
+    "net"
+)
+
+
+// Windows does not tell us which interface a packet arrived on.
+func (s *Ssdp) createRelaySocket(interfaces []net.Interface) error {
+    return fmt.Errorf("%w: windows", ErrRelayUnsupported)
+}
@@ -0,0 +1,15 @@
+package gossdp
+
+import (
+    "errors"
+    "log/slog"
+    "testing"
+)
+
+
+func TestRelayUnsupported(t *testing.T) {
+    s := newSsdp(nil, slog.Default())
+    if err := s.createRelaySocket(nil); !errors.Is(err, ErrRelayUnsupported) {
+        t.Errorf("got %v, want ErrRelayUnsupported", err)
+    }
+}
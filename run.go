@@ -0,0 +1,90 @@
+package gossdp
+
+import (
+    "context"
+    "fmt"
+    "io"
+)
+
+
+// Reads and writes packets until ctx is done, then shuts down as Shutdown does,
+// allowing up to 5 seconds for the byebyes to go out.
+//
+// Returns nil after a clean shutdown, including one started by Shutdown or Stop.
+// Otherwise it returns why it stopped: the socket failing, or the messages Shutdown
+// could not send. Run may only be called once.
+func (s *Ssdp) Run(ctx context.Context) error {
+    s.exitReadWaitGroup.Add(1)
+    s.exitWriteWaitGroup.Add(1)
+    go s.socketWriter()
+    readErr := make(chan error, 1)
+    go func () {
+        readErr <- s.socketReader()
+    }()
+
+    select {
+    case <- ctx.Done():
+        return s.shutdownAfter(ctx)
+    case err := <- readErr:
+        if !s.running() {
+            // Shutdown closed the socket
+            return nil
+        }
+        if err == io.EOF && s.replay != nil {
+            // the whole capture was replayed
+            return s.shutdownAfter(ctx)
+        }
+        s.logger.Warn("Error reading from SSDP socket", logDirection, "in", "error", err)
+        // nothing more can be sent, but stop the timers and the writer
+        s.shutdownAfter(ctx)
+        return fmt.Errorf("Error reading from SSDP socket: %w", err)
+    }
+}
+
+// Like Ssdp.Run, for the client.
+func (c *ClientSsdp) Run(ctx context.Context) error {
+    c.exitReadWaitGroup.Add(1)
+    c.exitWriteWaitGroup.Add(1)
+    go c.socketWriter()
+    readErr := make(chan error, 1)
+    go func () {
+        readErr <- c.socketReader()
+    }()
+
+    select {
+    case <- ctx.Done():
+        return c.shutdownAfter(ctx)
+    case err := <- readErr:
+        if !c.running() {
+            return nil
+        }
+        c.logger.Warn("Error reading from socket", logDirection, "in", "error", err)
+        c.shutdownAfter(ctx)
+        return fmt.Errorf("Error reading from socket: %w", err)
+    }
+}
+
+// Shuts down with a fresh deadline. ctx is usually already cancelled, but its values carry over.
+func (s *Ssdp) shutdownAfter(ctx context.Context) error {
+    shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stopTimeout)
+    defer cancel()
+    return s.Shutdown(shutdownCtx)
+}
+
+func (c *ClientSsdp) shutdownAfter(ctx context.Context) error {
+    shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stopTimeout)
+    defer cancel()
+    return c.Shutdown(shutdownCtx)
+}
+
+func (s *Ssdp) running() bool {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    return s.isRunning
+}
+
+func (c *ClientSsdp) running() bool {
+    c.interactionLock.Lock()
+    defer c.interactionLock.Unlock()
+    return c.isRunning
+}
@@ -0,0 +1,168 @@
+package gossdp
+
+import (
+    "math/rand"
+    "time"
+)
+
+
+// How advertisements are scheduled.
+//
+// A new device first sends ssdp:byebye for each of its USNs, in case an earlier
+// instance of it never did, then ssdp:alive. After that it re-advertises at random
+// points between a quarter and a half of its max-age, so the advertisement is
+// refreshed well before it expires.
+type AdvertiseSchedule struct {
+    // How many times each NOTIFY is sent. UDP is unreliable, so UPnP suggests
+    // sending each message more than once.
+    Repeat              int
+    // The pause between copies of the same NOTIFY.
+    RepeatInterval      time.Duration
+    // New devices wait a random time of up to this long before announcing themselves,
+    // so advertising many devices at once doesn't send them in one burst.
+    InitialJitter       time.Duration
+}
+
+// Each NOTIFY is sent twice, 200ms apart. New devices announce themselves within a second.
+var DefaultAdvertiseSchedule = AdvertiseSchedule{
+    Repeat              : 2,
+    RepeatInterval      : 200 * time.Millisecond,
+    InitialJitter       : time.Second,
+}
+
+// The max-age used for servers that don't set one. 30 minutes, as UPnP recommends.
+const defaultMaxAge = 1800
+
+// Changes how advertisements are scheduled. Applies to messages sent from now on.
+func (s *Ssdp) SetAdvertiseSchedule(schedule AdvertiseSchedule) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if schedule.Repeat < 1 {
+        schedule.Repeat = 1
+    }
+    s.schedule = schedule
+}
+
+// Starts advertising a new device. Must hold interactionLock.
+func (s *Ssdp) startDevice(d *advertisedDevice) {
+    s.scheduleDevice(d, randomDuration(s.schedule.InitialJitter), func () {
+        s.interactionLock.Lock()
+        defer s.interactionLock.Unlock()
+        if !s.isAdvertising(d) {
+            return
+        }
+        for _, n := range d.notifications() {
+            s.sendNotify(n.ads, d.notifyHeaders(n, "ssdp:byebye"))
+        }
+        // let the byebyes and their copies go out before we come back alive
+        s.scheduleDevice(d, time.Duration(s.schedule.Repeat) * s.schedule.RepeatInterval, func () {
+            s.readvertise(d)
+        })
+    })
+}
+
+// Sends ssdp:alive for every USN of the device, and schedules the next time.
+func (s *Ssdp) readvertise(d *advertisedDevice) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isAdvertising(d) {
+        return
+    }
+    for _, n := range d.notifications() {
+        s.sendNotify(n.ads, d.notifyHeaders(n, "ssdp:alive"))
+    }
+    s.scheduleDevice(d, readvertiseInterval(d.maxAge()), func () {
+        s.readvertise(d)
+    })
+}
+
+// Arms the device's timer. Must hold interactionLock.
+func (s *Ssdp) scheduleDevice(d *advertisedDevice, in time.Duration, f func()) {
+    s.statusLock.Lock()
+    d.nextAdvertise = time.Now().Add(in)
+    s.statusLock.Unlock()
+    d.timer = time.AfterFunc(in, f)
+}
+
+// Sends the remaining copies of a NOTIFY. Must hold interactionLock.
+// ssdp:alive is rendered again each time, so a repeat after UpdateServer carries the new headers.
+func (s *Ssdp) repeatNotify(ads *AdvertisableServer, heads map[string]string, msg writeMessage) {
+    nts, nt := heads["NTS"], heads["NT"]
+    for i := 1; i < s.schedule.Repeat; i++ {
+        time.AfterFunc(time.Duration(i) * s.schedule.RepeatInterval, func () {
+            s.interactionLock.Lock()
+            defer s.interactionLock.Unlock()
+            if !s.isRunning {
+                return
+            }
+            if nts == "ssdp:alive" {
+                // a server removed in the meantime must not come back alive
+                alive, ok := s.aliveMessage(ads.DeviceUuid, nt)
+                if !ok {
+                    return
+                }
+                s.writeChannel <- alive
+                return
+            }
+            s.writeChannel <- msg
+        })
+    }
+}
+
+// The current ssdp:alive for one USN of a device. False if the device no longer
+// advertises it. Must hold interactionLock.
+func (s *Ssdp) aliveMessage(deviceUuid, nt string) (writeMessage, bool) {
+    d, ok := s.devices[deviceUuid]
+    if !ok {
+        return writeMessage{}, false
+    }
+    for _, n := range d.notifications() {
+        if n.target.String() != nt {
+            continue
+        }
+        msg, err := s.notifyMessage(n.ads, d.notifyHeaders(n, "ssdp:alive"))
+        return msg, err == nil
+    }
+    return writeMessage{}, false
+}
+
+// True if the device is still advertised. Must hold interactionLock.
+func (s *Ssdp) isAdvertising(d *advertisedDevice) bool {
+    return s.isRunning && s.devices[d.uuid] == d
+}
+
+// The shortest max-age of the device's services. Its advertisements must not outlive any of them.
+func (d *advertisedDevice) maxAge() int {
+    age := d.services[0].MaxAge
+    for _, ads := range d.services[1:] {
+        if ads.MaxAge < age {
+            age = ads.MaxAge
+        }
+    }
+    return age
+}
+
+// Servers are stored with their max-age already defaulted, so the CACHE-CONTROL
+// header, search responses and the schedule all agree.
+func orDefaultMaxAge(maxAge int) int {
+    if maxAge <= 0 {
+        return defaultMaxAge
+    }
+    return maxAge
+}
+
+// A random point between a quarter and a half of the max-age.
+func readvertiseInterval(maxAge int) time.Duration {
+    half := time.Duration(maxAge) * time.Second / 2
+    if half < time.Second {
+        half = time.Second
+    }
+    return half / 2 + randomDuration(half / 2)
+}
+
+func randomDuration(max time.Duration) time.Duration {
+    if max <= 0 {
+        return 0
+    }
+    return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,129 @@
+package gossdp
+
+import (
+    "slices"
+    "testing"
+    "time"
+)
+
+
+func TestReadvertiseInterval(t *testing.T) {
+    tests := []struct {
+        maxAge      int
+        min         time.Duration
+        max         time.Duration
+    }{
+        {1800, 450 * time.Second, 900 * time.Second},
+        {100, 25 * time.Second, 50 * time.Second},
+        // never more often than every half second
+        {1, 500 * time.Millisecond, time.Second},
+    }
+    for _, tt := range tests {
+        seen := make(map[time.Duration]bool)
+        for i := 0; i < 100; i++ {
+            d := readvertiseInterval(tt.maxAge)
+            if d < tt.min || d >= tt.max {
+                t.Errorf("max-age %d: %v, want between %v and %v", tt.maxAge, d, tt.min, tt.max)
+            }
+            seen[d] = true
+        }
+        if len(seen) < 2 {
+            t.Errorf("max-age %d: always %v, want jitter", tt.maxAge, readvertiseInterval(tt.maxAge))
+        }
+    }
+}
+
+func TestDeviceMaxAge(t *testing.T) {
+    if orDefaultMaxAge(0) != 1800 || orDefaultMaxAge(-1) != 1800 || orDefaultMaxAge(60) != 60 {
+        t.Errorf("orDefaultMaxAge: %d %d %d", orDefaultMaxAge(0), orDefaultMaxAge(-1), orDefaultMaxAge(60))
+    }
+    d := &advertisedDevice{services: []*AdvertisableServer{{MaxAge: 1800}, {MaxAge: 120}, {MaxAge: 600}}}
+    if d.maxAge() != 120 {
+        t.Errorf("got %d, want the shortest, 120", d.maxAge())
+    }
+}
+
+func TestAdvertiseSchedule(t *testing.T) {
+    s := testSsdp()
+    s.SetAdvertiseSchedule(AdvertiseSchedule{Repeat: 2, RepeatInterval: 20 * time.Millisecond})
+    ads := testServer()
+    ads.MaxAge = 100
+    start := time.Now()
+    if err := s.AdvertiseServer(ads); err != nil {
+        t.Fatal(err)
+    }
+
+    // three USNs, each said goodbye to and then alive, twice
+    var sent []writeMessage
+    deadline := time.After(5 * time.Second)
+    for len(sent) < 12 {
+        select {
+        case msg := <- s.writeChannel:
+            sent = append(sent, msg)
+        case <- deadline:
+            t.Fatalf("sent %d messages, want 12", len(sent))
+        }
+    }
+    s.interactionLock.Lock()
+    s.interactionLock.Unlock()
+    if extra := queued(s); len(extra) != 0 {
+        t.Errorf("sent %d more messages", len(extra))
+    }
+    reqs := parseNotifies(t, sent)
+    usns := []string{"upnp:rootdevice", "upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:1", "urn:schemas-upnp-org:device:MediaServer:1",
+        "uuid:" + testDeviceUuid, "uuid:" + testDeviceUuid}
+    if got := notifyTargets(reqs[:6], "ssdp:byebye"); !slices.Equal(got, usns) {
+        t.Errorf("first sent ssdp:byebye for %v, want %v", got, usns)
+    }
+    if got := notifyTargets(reqs[6:], "ssdp:alive"); !slices.Equal(got, usns) {
+        t.Errorf("then sent ssdp:alive for %v, want %v", got, usns)
+    }
+
+    // the next ssdp:alive is due between a quarter and a half of max-age from now
+    next := s.Servers()[0].NextScheduled
+    if next.Before(start.Add(25 * time.Second)) || next.After(time.Now().Add(50 * time.Second)) {
+        t.Errorf("next ssdp:alive in %v, want between 25s and 50s", next.Sub(start))
+    }
+}
+
+// The copies of ssdp:alive carry what UpdateServer changed in the meantime.
+func TestAdvertiseRepeatAfterUpdate(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    s.SetAdvertiseSchedule(AdvertiseSchedule{Repeat: 2, RepeatInterval: 100 * time.Millisecond})
+
+    s.interactionLock.Lock()
+    d := s.devices[testDeviceUuid]
+    s.sendNotify(d.services[0], d.notifyHeaders(notification{SearchTarget{Kind: TargetRootDevice}, d.services[0]}, "ssdp:alive"))
+    s.interactionLock.Unlock()
+    err := s.UpdateServer(testDeviceUuid, func (ads *AdvertisableServer) {
+        ads.ExtraHeaders = map[string]string{"X-Test": "2"}
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    queued(s)
+
+    // our repeat, and those of the three ssdp:alive UpdateServer sent
+    var repeats []writeMessage
+    deadline := time.After(5 * time.Second)
+    for len(repeats) < 4 {
+        select {
+        case msg := <- s.writeChannel:
+            repeats = append(repeats, msg)
+        case <- deadline:
+            t.Fatalf("%d ssdp:alive were repeated, want 4", len(repeats))
+        }
+    }
+    for _, msg := range repeats {
+        if got := messageHeader(msg.message, "X-Test"); got != "2" {
+            t.Errorf("the repeat for %s carried X-Test %q, want 2", messageHeader(msg.message, "NT"), got)
+        }
+        if got := messageHeader(msg.message, "CONFIGID.UPNP.ORG"); got != "1" {
+            t.Errorf("the repeat for %s carried CONFIGID %q, want 1", messageHeader(msg.message, "NT"), got)
+        }
+    }
+}
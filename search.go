@@ -0,0 +1,269 @@
+package gossdp
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+
+const (
+    ssdpMulticastHost = "239.255.255.250"
+    ssdpPort = 1900
+    // The largest MX allowed by UPnP 1.0
+    maxSearchWait = 120
+    // UPnP 1.1 devices treat any larger MX as 5
+    maxResponseDelay = 5
+)
+
+var (
+    ErrSearchMissingHost = errors.New("M-SEARCH rejected: missing HOST")
+    ErrSearchBadHost = errors.New("M-SEARCH rejected: multicast HOST is not 239.255.255.250:1900")
+    ErrSearchBadMan = errors.New(`M-SEARCH rejected: MAN is not "ssdp:discover"`)
+    ErrSearchMissingMx = errors.New("M-SEARCH rejected: missing MX on a multicast search")
+    ErrSearchBadMx = errors.New("M-SEARCH rejected: MX is not an integer between 1 and 120")
+    ErrSearchMissingSt = errors.New("M-SEARCH rejected: missing ST")
+)
+
+// An incoming M-SEARCH request:
+//      M-SEARCH * HTTP/1.1
+//      HOST: 239.255.255.250:1900
+//      MAN: "ssdp:discover"                                 // message sub-type
+//      MX: 3                                                // maximum wait time in seconds
+//      ST: ge:fridge                                        // search target
+//      USER-AGENT: OS/version UPnP/1.1 product/version      // optional
+//      CPFN.UPNP.ORG: friendly name of the control point    // optional
+//      CPUUID.UPNP.ORG: uuid of the control point           // optional
+//
+// Searches sent directly to us (unicast) may omit MX, and are answered straight away.
+type SearchMessage struct {
+    // The HOST header. 239.255.255.250:1900 for multicast searches
+    Host                string
+    // The MAN header, with its quotes
+    Man                 string
+    // MX. How many seconds the searcher will wait for responses. 0 for unicast searches
+    MaxWait             int
+    // Search Target. What the searcher is looking for
+    SearchType          SearchTarget
+    // The USER-AGENT of the control point, if sent
+    UserAgent           string
+    // The CPFN.UPNP.ORG header. The friendly name of the control point, if sent
+    ControlPointName    string
+    // The CPUUID.UPNP.ORG header. The UUID of the control point, if sent
+    ControlPointUuid    string
+    // True if the search was sent to the multicast group
+    Multicast           bool
+    // The host:port the search came from. This is where responses go
+    Source              string
+    // Why the search was rejected. nil if it was valid
+    Rejected            error
+    // The parsed request
+    RawRequest          *http.Request
+}
+
+// Optionally implemented by an SsdpListener to see every incoming M-SEARCH,
+// including those we rejected.
+type SearchListener interface {
+    NotifySearch(message SearchMessage)
+}
+
+// A response we are about to send to an M-SEARCH.
+type SearchResponse struct {
+    // The ST of the response
+    SearchType          SearchTarget
+    // The USN of the response
+    Usn                 USN
+    // The location of the service being advertised
+    Location            string
+    // How long the response should be considered valid for
+    MaxAge              int
+    // Extension headers to send with the response
+    ExtraHeaders        map[string]string
+    // The BOOTID.UPNP.ORG and CONFIGID.UPNP.ORG of the device. Not sent when negative
+    BootId              int
+    ConfigId            int
+}
+
+// Observes every valid M-SEARCH before we answer it.
+// It is given the responses we intend to send and returns the ones to actually send.
+// Returning nil vetoes the search, and returning extra responses answers for
+// services we do not advertise ourselves.
+//
+// An SsdpListener that implements SearchObserver is used automatically.
+type SearchObserver interface {
+    ObserveSearch(message SearchMessage, responses []SearchResponse) []SearchResponse
+}
+
+// Sets the SearchObserver. nil removes it.
+func (s *Ssdp) SetSearchObserver(o SearchObserver) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    s.searchObserver = o
+}
+
+// Parses and validates an M-SEARCH. The returned message is filled in as far as
+// possible, with Rejected set to the first rule it breaks.
+func parseSearch(req *http.Request, hostPort string) SearchMessage {
+    msg := SearchMessage{
+        Host                : req.Header.Get("HOST"),
+        Man                 : strings.TrimSpace(req.Header.Get("MAN")),
+        SearchType          : toSearchTarget(req.Header.Get("ST")),
+        UserAgent           : req.Header.Get("USER-AGENT"),
+        ControlPointName    : req.Header.Get("CPFN.UPNP.ORG"),
+        ControlPointUuid    : req.Header.Get("CPUUID.UPNP.ORG"),
+        Source              : hostPort,
+        RawRequest          : req,
+    }
+    if msg.Host == "" {
+        // net/http moves the Host header out of the header map
+        msg.Host = req.Host
+    }
+    msg.Multicast, msg.Rejected = validateSearchHost(msg.Host)
+    if msg.Rejected != nil {
+        return msg
+    }
+    if strings.Trim(msg.Man, `"`) != "ssdp:discover" {
+        msg.Rejected = ErrSearchBadMan
+        return msg
+    }
+    if mx := strings.TrimSpace(req.Header.Get("MX")); mx != "" {
+        mxInt, err := strconv.Atoi(mx)
+        if err != nil || mxInt < 1 || mxInt > maxSearchWait {
+            msg.Rejected = ErrSearchBadMx
+            return msg
+        }
+        msg.MaxWait = mxInt
+    } else if msg.Multicast {
+        msg.Rejected = ErrSearchMissingMx
+        return msg
+    }
+    if msg.SearchType.IsZero() {
+        msg.Rejected = ErrSearchMissingSt
+        return msg
+    }
+    return msg
+}
+
+// A multicast HOST must be the SSDP group. Anything else is a unicast search.
+func validateSearchHost(host string) (multicast bool, err error) {
+    if host == "" {
+        return false, ErrSearchMissingHost
+    }
+    h, port, splitErr := net.SplitHostPort(host)
+    if splitErr != nil {
+        h, port = host, strconv.Itoa(ssdpPort)
+    }
+    ip := net.ParseIP(h)
+    if ip == nil || !ip.IsMulticast() {
+        return false, nil
+    }
+    if !ip.Equal(net.ParseIP(ssdpMulticastHost)) || port != strconv.Itoa(ssdpPort) {
+        return true, ErrSearchBadHost
+    }
+    return true, nil
+}
+
+// How long to wait before answering. A random point within MX, capped at 5 seconds.
+func (m SearchMessage) responseDelay() time.Duration {
+    if !m.Multicast || m.MaxWait < 1 {
+        return 0
+    }
+    mx := m.MaxWait
+    if mx > maxResponseDelay {
+        mx = maxResponseDelay
+    }
+    return randomDuration(time.Duration(mx) * time.Second)
+}
+
+
+func (s *Ssdp) msearch(req * http.Request, hostPort string) {
+    received := time.Now()
+    msg := parseSearch(req, hostPort)
+    s.metrics.PacketIn("M-SEARCH", "")
+    s.dispatch(Event{Type: EventSearch, Source: hostPort, Search: &msg})
+    if msg.Rejected != nil {
+        s.logger.Debug("Ignoring M-SEARCH", logSource, hostPort, logSt, msg.SearchType.String(), "error", msg.Rejected)
+        s.metrics.ParseFailure()
+        return
+    }
+    if !s.searchLimiter.allow(hostPort, received) {
+        s.logger.Debug("Rate limiting M-SEARCH", logSource, hostPort, logSt, msg.SearchType.String())
+        s.metrics.SearchRateLimited()
+        return
+    }
+    s.inMSearch(msg, received)
+}
+
+func (s *Ssdp) inMSearch(msg SearchMessage, received time.Time) {
+    responses := s.matchSearch(msg.SearchType)
+
+    s.interactionLock.Lock()
+    observer := s.searchObserver
+    s.interactionLock.Unlock()
+    if observer != nil {
+        responses = observer.ObserveSearch(msg, responses)
+    }
+    if len(responses) == 0 {
+        return
+    }
+    // answer off the reader goroutine, so we keep reading while we wait out MX
+    time.AfterFunc(msg.responseDelay(), func () {
+        for _, r := range responses {
+            s.respondToMSearch(r, msg.Source, received)
+        }
+    })
+}
+
+func (s *Ssdp) respondToMSearch(r SearchResponse, sendTo string, received time.Time) {
+    // a SearchObserver may have made the response up
+    err := checkHeader("ST", r.SearchType.String())
+    if err == nil {
+        err = checkHeader("USN", r.Usn.String())
+    }
+    if err == nil {
+        err = checkExtraHeaders(r.Location, r.ExtraHeaders)
+    }
+    if err != nil {
+        s.logger.Warn("Not sending M-SEARCH response", logDestination, sendTo, logUsn, r.Usn.String(), "error", err)
+        return
+    }
+    heads := map[string]string{
+        "ST": r.SearchType.String(),
+        "USN": r.Usn.String(),
+        "LOCATION": r.Location,
+        "CACHE-CONTROL": fmt.Sprintf("max-age=%d", r.MaxAge),
+        "DATE": time.Now().Format(time.RFC1123),
+        "SERVER": serverName,
+        "EXT": "",
+    }
+    if r.BootId >= 0 {
+        heads["BOOTID.UPNP.ORG"] = strconv.Itoa(r.BootId)
+    }
+    if r.ConfigId >= 0 {
+        heads["CONFIGID.UPNP.ORG"] = strconv.Itoa(r.ConfigId)
+    }
+    addExtraHeaders(heads, r.ExtraHeaders)
+    msg := createSsdpHeader(
+        "200 OK",
+        heads,
+        true,
+    )
+
+    addr, err := net.ResolveUDPAddr("udp4", sendTo)
+    if err != nil {
+        s.logger.Error("Error resolving UDP addr", logDestination, sendTo, logSt, r.SearchType.String(), logUsn, r.Usn.String(), "error", err)
+        return
+    }
+
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    if !s.isRunning  {
+        return
+    }
+
+    s.writeChannel <- writeMessage{message: msg, to: addr, received: received}
+}
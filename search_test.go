@@ -0,0 +1,68 @@
+package gossdp
+
+import (
+    "bufio"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+
+func testSearchRequest(t *testing.T, headers string) *http.Request {
+    req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("M-SEARCH * HTTP/1.1\r\n" + headers + "\r\n")))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return req
+}
+
+func TestParseSearch(t *testing.T) {
+    const group = "HOST: 239.255.255.250:1900\r\n"
+    const discover = "MAN: \"ssdp:discover\"\r\n"
+    tests := []struct {
+        name        string
+        headers     string
+        multicast   bool
+        mx          int
+        st          SearchTarget
+        err         error
+    }{
+        {"multicast", group + discover + "MX: 3\r\nST: ssdp:all\r\n", true, 3, SearchTarget{Kind: TargetAll}, nil},
+        {"lowest MX", group + discover + "MX: 1\r\nST: upnp:rootdevice\r\n", true, 1, SearchTarget{Kind: TargetRootDevice}, nil},
+        {"highest MX", group + discover + "MX: 120\r\nST: upnp:rootdevice\r\n", true, 120, SearchTarget{Kind: TargetRootDevice}, nil},
+        {"MX 0", group + discover + "MX: 0\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadMx},
+        {"MX over 120", group + discover + "MX: 121\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadMx},
+        {"MX not a number", group + discover + "MX: soon\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadMx},
+        {"missing MX", group + discover + "ST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchMissingMx},
+        {"unicast without MX", "HOST: 192.168.1.20:1900\r\n" + discover + "ST: ssdp:all\r\n", false, 0, SearchTarget{Kind: TargetAll}, nil},
+        {"wrong group", "HOST: 239.255.255.251:1900\r\n" + discover + "MX: 3\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadHost},
+        {"wrong port", "HOST: 239.255.255.250:1901\r\n" + discover + "MX: 3\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadHost},
+        {"bad MAN", group + "MAN: ssdp:alive\r\nMX: 3\r\nST: ssdp:all\r\n", true, 0, SearchTarget{Kind: TargetAll}, ErrSearchBadMan},
+        {"missing ST", group + discover + "MX: 3\r\n", true, 3, SearchTarget{}, ErrSearchMissingSt},
+        {"uuid", group + discover + "MX: 2\r\nST: uuid:2fac1234-31f8-11b4-a222-08002b34c003\r\n", true, 2, UuidTarget("2fac1234-31f8-11b4-a222-08002b34c003"), nil},
+        {"versioned device", group + discover + "MX: 2\r\nST: urn:schemas-upnp-org:device:MediaServer:2\r\n", true, 2, DeviceTarget("schemas-upnp-org", "MediaServer", 2), nil},
+        {"versioned service", group + discover + "MX: 2\r\nST: urn:schemas-upnp-org:service:ContentDirectory:4\r\n", true, 2, ServiceTarget("schemas-upnp-org", "ContentDirectory", 4), nil},
+        // a USN is not a search target, but is kept rather than rejected
+        {"uuid with urn", group + discover + "MX: 2\r\nST: uuid:2fac1234-31f8-11b4-a222-08002b34c003::urn:schemas-upnp-org:device:MediaServer:1\r\n", true, 2,
+            SearchTarget{Kind: TargetOther, raw: "uuid:2fac1234-31f8-11b4-a222-08002b34c003::urn:schemas-upnp-org:device:MediaServer:1"}, nil},
+    }
+    for _, tt := range tests {
+        msg := parseSearch(testSearchRequest(t, tt.headers), "192.168.1.30:50000")
+        if msg.Rejected != tt.err {
+            t.Errorf("%s: rejected %v, want %v", tt.name, msg.Rejected, tt.err)
+            continue
+        }
+        if msg.Multicast != tt.multicast {
+            t.Errorf("%s: multicast %v, want %v", tt.name, msg.Multicast, tt.multicast)
+        }
+        if msg.MaxWait != tt.mx {
+            t.Errorf("%s: MX %d, want %d", tt.name, msg.MaxWait, tt.mx)
+        }
+        if msg.SearchType != tt.st {
+            t.Errorf("%s: ST %+v, want %+v", tt.name, msg.SearchType, tt.st)
+        }
+        if msg.Source != "192.168.1.30:50000" {
+            t.Errorf("%s: source %q", tt.name, msg.Source)
+        }
+    }
+}
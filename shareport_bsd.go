@@ -0,0 +1,16 @@
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package gossdp
+
+import (
+    "syscall"
+)
+
+
+// BSDs only let a multicast port be shared when every socket sets SO_REUSEPORT.
+func setSharePort(fd int) error {
+    if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+        return err
+    }
+    return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,13 @@
+// +build linux nacl solaris
+
+package gossdp
+
+import (
+    "syscall"
+)
+
+
+// SO_REUSEADDR is enough to share a UDP port on linux.
+func setSharePort(fd int) error {
+    return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
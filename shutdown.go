@@ -0,0 +1,154 @@
+package gossdp
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "time"
+)
+
+
+// How many messages can wait for the writer before senders block.
+const writeQueueSize = 64
+
+// How long Stop waits for byebyes and queued messages to go out.
+const stopTimeout = 5 * time.Second
+
+// Stops the server gracefully.
+// Every advertised device says ssdp:byebye for each of its USNs, repeated as the
+// AdvertiseSchedule asks, and everything queued is written before the socket closes.
+// If ctx is done first, the socket is closed anyway and the returned error says what
+// was left unsent.
+func (s *Ssdp) Shutdown(ctx context.Context) error {
+    s.interactionLock.Lock()
+    if !s.isRunning {
+        s.interactionLock.Unlock()
+        return nil
+    }
+    // from here on timers, repeats and search responses stop queueing messages
+    s.isRunning = false
+    var byes []writeMessage
+    for _, d := range s.devices {
+        d.timer.Stop()
+        for _, n := range d.notifications() {
+            if msg, err := s.notifyMessage(n.ads, d.notifyHeaders(n, "ssdp:byebye")); err == nil {
+                byes = append(byes, msg)
+            }
+        }
+    }
+    repeat := s.schedule.Repeat
+    interval := s.schedule.RepeatInterval
+    s.interactionLock.Unlock()
+
+    unsent := 0
+    for i := 0; i < repeat; i++ {
+        if i > 0 && sleepContext(ctx, interval) != nil {
+            unsent += len(byes) * (repeat - i)
+            break
+        }
+        for k := range byes {
+            select {
+            case s.writeChannel <- byes[k]:
+            case <- ctx.Done():
+                unsent++
+            }
+        }
+    }
+
+    s.interactionLock.Lock()
+    // nobody else sends once we are not running, so the writer drains the queue and exits
+    close(s.writeChannel)
+    s.interactionLock.Unlock()
+    dropped := writerLeftovers(ctx, &s.exitWriteWaitGroup, s.writeChannel)
+
+    if s.replay != nil {
+        s.events.stop()
+        s.replay.close()
+        s.exitReadWaitGroup.Wait()
+    } else if s.socket.IsValid() {
+        s.events.stop()
+        s.closeSocket()
+        s.exitReadWaitGroup.Wait()
+    }
+    s.events.close()
+    trace(s.logger, "Shutdown exiting")
+    return shutdownError(ctx, unsent, dropped)
+}
+
+// Stops the client gracefully.
+// Queued M-SEARCH requests are written before the socket closes. If ctx is done first,
+// the socket is closed anyway and the returned error says what was left unsent.
+func (c *ClientSsdp) Shutdown(ctx context.Context) error {
+    c.interactionLock.Lock()
+    if !c.isRunning {
+        c.interactionLock.Unlock()
+        return nil
+    }
+    c.isRunning = false
+    // nobody sends once we are not running, so the writer can drain and exit
+    close(c.writeChannel)
+    c.interactionLock.Unlock()
+
+    dropped := writerLeftovers(ctx, &c.exitWriteWaitGroup, c.writeChannel)
+
+    if c.socket != nil {
+        c.events.stop()
+        c.socket.Close()
+        c.exitReadWaitGroup.Wait()
+    }
+    c.events.close()
+    trace(c.logger, "Shutdown exiting")
+    return shutdownError(ctx, 0, dropped)
+}
+
+// Waits for the writer to empty the closed queue and counts what it left behind.
+// If ctx is done first the writer is still running; closing the socket makes it fail
+// through the rest and exit, so the queue is only counted, never taken from under it.
+func writerLeftovers(ctx context.Context, writer *sync.WaitGroup, queue chan writeMessage) int {
+    if waitContext(ctx, writer) != nil {
+        return len(queue)
+    }
+    // only a writer that never started leaves anything here
+    dropped := 0
+    for range queue {
+        dropped++
+    }
+    return dropped
+}
+
+func shutdownError(ctx context.Context, unsentByes, dropped int) error {
+    if unsentByes == 0 && dropped == 0 {
+        return nil
+    }
+    if ctx.Err() == nil {
+        // the writer was never started
+        return fmt.Errorf("Shutdown incomplete: %d byebye messages not queued, %d queued messages not written: not running", unsentByes, dropped)
+    }
+    return fmt.Errorf("Shutdown incomplete: %d byebye messages not queued, %d queued messages not written: %w", unsentByes, dropped, ctx.Err())
+}
+
+// Waits for the group, giving up when ctx is done.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+    done := make(chan struct{})
+    go func () {
+        wg.Wait()
+        close(done)
+    }()
+    select {
+    case <- done:
+        return nil
+    case <- ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+    case <- t.C:
+        return nil
+    case <- ctx.Done():
+        return ctx.Err()
+    }
+}
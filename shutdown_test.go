@@ -0,0 +1,80 @@
+package gossdp
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "testing"
+    "time"
+)
+
+
+// Stands in for the socket writer, handing over what it takes once the queue closes.
+func testWriter(s *Ssdp, release <-chan struct{}) <-chan []writeMessage {
+    written := make(chan []writeMessage, 1)
+    s.exitWriteWaitGroup.Add(1)
+    go func () {
+        defer s.exitWriteWaitGroup.Done()
+        <- release
+        var msgs []writeMessage
+        for msg := range s.writeChannel {
+            msgs = append(msgs, msg)
+        }
+        written <- msgs
+    }()
+    return written
+}
+
+func TestShutdownSendsByebye(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    s.SetAdvertiseSchedule(AdvertiseSchedule{Repeat: 2, RepeatInterval: 20 * time.Millisecond})
+    release := make(chan struct{})
+    close(release)
+    written := testWriter(s, release)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    if err := s.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+    reqs := parseNotifies(t, <- written)
+    usns := []string{"upnp:rootdevice", "upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:1", "urn:schemas-upnp-org:device:MediaServer:1",
+        "uuid:" + testDeviceUuid, "uuid:" + testDeviceUuid}
+    if got := notifyTargets(reqs, "ssdp:byebye"); !slices.Equal(got, usns) || len(reqs) != len(usns) {
+        t.Errorf("sent ssdp:byebye for %v, %d messages in all, want %v", got, len(reqs), usns)
+    }
+
+    if err := s.AdvertiseServer(testServer()); err == nil {
+        t.Error("advertised after Shutdown")
+    }
+    if err := s.Shutdown(ctx); err != nil {
+        t.Errorf("a second Shutdown: %v", err)
+    }
+}
+
+func TestShutdownDeadline(t *testing.T) {
+    s := testSsdp()
+    if err := s.AdvertiseServer(testServer()); err != nil {
+        t.Fatal(err)
+    }
+    waitAlive(t, s)
+    // a writer that is stuck, as on a full socket buffer
+    release := make(chan struct{})
+    defer close(release)
+    testWriter(s, release)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    err := s.Shutdown(ctx)
+    if took := time.Since(start); took > time.Second {
+        t.Errorf("Shutdown took %v, past its deadline", took)
+    }
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("got %v, want the deadline", err)
+    }
+}
@@ -35,15 +35,15 @@ Client
 ======
     // create the client, passing in the listener, binding the socket
     // Notice: the client does not listen to broadcasts.. see client doc
-    client err := gossdp.NewSsdpClient(b)
+    c, err := gossdp.NewSsdpClient(b)
     if err != nil {
         log.Println("Failed to start client: ", err)
         return
     }
-    // call stop  when we are done
-    defer c.Stop()
-    // run! this will block until stop is called. so open it in a goroutine here
-    go c.Start()
+    // run! this will block until ctx is cancelled. so open it in a goroutine here
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go c.Run(ctx)
 
     // send a request for the server type we are listening for.
     err = c.ListenFor("urn:fromkeith:test:web:0")
@@ -59,20 +59,29 @@ Server
         log.Println("Error creating ssdp server: ", err)
         return
     }
-    // call stop  when we are done
-    defer s.Stop()
-    // run! this will block until stop is called. so open it in a goroutine here
-    go s.Start()
+    // run! this will block until ctx is cancelled, and then send byebyes for
+    // everything we advertised. so open it in a goroutine here
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go s.Run(ctx)
 
+    // a UUID unique to this machine, that stays the same across restarts
+    uuid, err := gossdp.LocalDeviceUuid("my product")
+    if err != nil {
+        log.Println("Error making device uuid: ", err)
+        return
+    }
     // Define the service we want to advertise
     serverDef := gossdp.AdvertisableServer{
         ServiceType: "urn:fromkeith:test:web:0",            // define the service type
-        DeviceUuid: "hh0c2981-0029-44b7-4u04-27f187aecf78", // make this unique!
+        DeviceUuid: uuid,                                   // eg. 0e0c2981-0029-44b7-a404-27f187aecf78
         Location: "http://192.168.1.1:8080",                // this is the location of the service we are advertising
         MaxAge: 3600,                                       // Max age this advertisment is valid for
     }
     // start advertising it!
-    s.AdvertiseServer(serverDef)
+    if err := s.AdvertiseServer(serverDef); err != nil {
+        log.Println("Error advertising: ", err)
+    }
 
 
 
@@ -94,9 +103,11 @@ USN:
 package gossdp
 
 import (
+    "context"
     "strings"
     "regexp"
     "log"
+    "log/slog"
     "time"
     "net"
     "fmt"
@@ -106,6 +117,7 @@ import (
     "net/http"
     "bufio"
     "runtime"
+    "sort"
     "sync"
 )
 
@@ -143,23 +155,44 @@ var (
 
 // a SSDP defintion
 type Ssdp struct {
-    advertisableServers     map[string][]*AdvertisableServer
-    deviceIdToServer        map[string]*AdvertisableServer
+    devices                 map[string]*advertisedDevice
     socket                  theSocket
     listener                SsdpListener
     listenSearchTargets     map[string]bool
+    searchMatchers          []SearchMatcher
+    searchObserver          SearchObserver
     writeChannel            chan writeMessage
     exitWriteWaitGroup      sync.WaitGroup
     exitReadWaitGroup       sync.WaitGroup
     interactionLock         sync.Mutex
+    // guards the status of advertised servers, which the writer updates
+    statusLock              sync.Mutex
     isRunning               bool
-    logger                  LoggerInterface
+    events                  eventStream
+    subscribers             subscriberList
+    logger                  *slog.Logger
+    metrics                 Metrics
+    capture                 PacketCapture
+    // set when reading a capture instead of the socket
+    replay                  *replaySource
+    // the packet being parsed. Only used on the reader goroutine
+    reading                 Packet
+    searchLimiter           searchLimiter
+    bootId                  int
+    schedule                AdvertiseSchedule
+    // lets other sockets bind :1900 too. See NewSharedSsdp
+    sharePort               bool
 }
 
 type writeMessage struct {
     message             []byte
     to                  *net.UDPAddr
-    shouldExit          bool
+    // called by the writer once the message went out, or failed to
+    sent                func(err error)
+    // for responses, when the M-SEARCH was read
+    received            time.Time
+    // the interface to send from. 0 lets the system choose
+    ifIndex             int
 }
 
 
@@ -176,20 +209,28 @@ type writeMessage struct {
 //      SERVER: WIN/8.1 UPnP/1.0 gossdp/0.1                  // Concat of OS, UPnP, and product.
 type AliveMessage struct {
     // Search Target. The urn: that defines what type of resource it is
-    SearchType      string
-    // Its unique identifier
-    DeviceId        string
-    // The USN of the service. uuid:DeviceId:SearchType
-    Usn             string
+    SearchType      SearchTarget
+    // The USN of the service. uuid:device-UUID::SearchType
+    Usn             USN
     // The location of the service being advertised
     Location        string
     // How long this message should be considered valid for
     MaxAge          int
     // The os/generic info about the SSDP server
     Server          string
+    // BOOTID.UPNP.ORG. Increases each time the device reboots or moves. -1 if not sent
+    BootId          int
+    // CONFIGID.UPNP.ORG. Changes with the device description. -1 if not sent
+    ConfigId        int
     // The parsed request
     RawRequest      *http.Request
-    // The urn part of the USN
+    // The device UUID of the USN.
+    //
+    // Deprecated: use Usn.Uuid
+    DeviceId        string
+    // The part of the USN after the device UUID. Eg. upnp:rootdevice
+    //
+    // Deprecated: use Usn.Target
     Urn             string
 }
 
@@ -201,17 +242,52 @@ type AliveMessage struct {
 //      USN: uuid:the:unique
 type ByeMessage struct {
     // Search Target. The urn: that defines what type of resource it is
-    SearchType      string
-    // Its unique identifier
-    DeviceId        string
-    // The USN of the service. uuid:DeviceId:SearchType
-    Usn             string
+    SearchType      SearchTarget
+    // The USN of the service. uuid:device-UUID::SearchType
+    Usn             USN
+    // BOOTID.UPNP.ORG. -1 if not sent
+    BootId          int
+    // CONFIGID.UPNP.ORG. -1 if not sent
+    ConfigId        int
     // The parsed request
     RawRequest      *http.Request
-    // The urn part of the USN
+    // The device UUID of the USN.
+    //
+    // Deprecated: use Usn.Uuid
+    DeviceId        string
+    // The part of the USN after the device UUID. Eg. upnp:rootdevice
+    //
+    // Deprecated: use Usn.Target
     Urn             string
 }
 
+// Notify (update). Sent by UPnP 1.1 devices when their BOOTID is about to change,
+// for example when they move to a new address.
+//      NOTIFY * HTTP/1.1
+//      Host: 239.255.255.250:1900
+//      NT: search:target
+//      NTS: ssdp:update
+//      USN: uuid:the:unique
+//      LOCATION: http://foo/bar
+//      BOOTID.UPNP.ORG: 7
+//      NEXTBOOTID.UPNP.ORG: 8
+type UpdateMessage struct {
+    // Search Target. The urn: that defines what type of resource it is
+    SearchType      SearchTarget
+    // The USN of the service. uuid:device-UUID::SearchType
+    Usn             USN
+    // The location of the service being advertised
+    Location        string
+    // The BOOTID.UPNP.ORG in use until now
+    BootId          int
+    // NEXTBOOTID.UPNP.ORG. The BOOTID future messages will carry
+    NextBootId      int
+    // CONFIGID.UPNP.ORG. -1 if not sent
+    ConfigId        int
+    // The parsed request
+    RawRequest      *http.Request
+}
+
 // M-Search Response:
 //      HTTP/1.1 200 OK
 //      Ext:                                                 // required by http extension framework. just key, no value
@@ -225,19 +301,27 @@ type ResponseMessage struct {
     // How long this message should be considered valid for
     MaxAge              int
     // Search Target. The urn: that defines what type of resource it is
-    SearchType          string
-    // Its unique identifier
-    DeviceId            string
-    // The USN of the service. uuid:DeviceId:SearchType
-    Usn                 string
+    SearchType          SearchTarget
+    // The USN of the service. uuid:device-UUID::SearchType
+    Usn                 USN
     // The location of the service being advertised
     Location            string
     // The os/generic info about the SSDP server
     Server              string
+    // BOOTID.UPNP.ORG. -1 if not sent
+    BootId              int
+    // CONFIGID.UPNP.ORG. -1 if not sent
+    ConfigId            int
     // The parsed response
     RawResponse         *http.Response
-    // The urn part of the USN
-    Urn             string
+    // The device UUID of the USN.
+    //
+    // Deprecated: use Usn.Uuid
+    DeviceId            string
+    // The part of the USN after the device UUID. Eg. upnp:rootdevice
+    //
+    // Deprecated: use Usn.Target
+    Urn                 string
 }
 
 // Listener to recieve events.
@@ -246,10 +330,15 @@ type SsdpListener interface {
     NotifyAlive(message AliveMessage)
     // Notified on ssdp:byebye messages. Only for those we are listening for.
     NotifyBye(message ByeMessage)
-    // Notified on M-SEARCH responses.
+    // Notified on M-SEARCH responses. Only for those we are listening for.
     Response(message ResponseMessage)
 }
 
+// Optionally implemented by an SsdpListener to be told of ssdp:update messages.
+type UpdateListener interface {
+    NotifyUpdate(message UpdateMessage)
+}
+
 // reference doc: http://www.upnp.org/specs/arch/UPnP-arch-DeviceArchitecture-v1.0-20081015.pdf
 
 
@@ -266,127 +355,81 @@ type SsdpListener interface {
 
 
 
-// Describes the server/service we wish to advertise
-type AdvertisableServer struct {
-    // The type of this service. In the URN it is pasted after the device-UUID.
-    //  It is what devices will search for
-    ServiceType             string
-    // The unique identifier of this device.
-    DeviceUuid              string
-    // The location of the service we are advertising. Eg. http://192.168.0.2:3434
-    Location                string
-    // The max number of seconds we want advertise and responses to be valid for.
-    MaxAge                  int
-
-    usn                     string
-    lastTimer               *time.Timer
-    last3sTimer             *time.Timer
+// Creates a new server, logging to slog.Default()
+func NewSsdp(l SsdpListener) (*Ssdp, error) {
+    return NewSsdpWithSlog(l, slog.Default())
 }
 
-// Register a service to advertise
-// Should only be called once per server
-// This implementation will automatically adverise when maxAge expires.
-func (s *Ssdp) AdvertiseServer(ads AdvertisableServer) {
-    s.interactionLock.Lock()
-    defer s.interactionLock.Unlock()
-    if !s.isRunning {
-        return
-    }
-
-    adsPointer := &ads
-
-    adsPointer.usn = fmt.Sprintf("uuid:%s::%s", adsPointer.DeviceUuid, adsPointer.ServiceType)
-    if v, ok := s.advertisableServers[adsPointer.ServiceType]; ok {
-        s.advertisableServers[adsPointer.ServiceType] = append(v, adsPointer)
-    } else {
-        s.advertisableServers[adsPointer.ServiceType] = []*AdvertisableServer{adsPointer}
-    }
-    s.deviceIdToServer[adsPointer.DeviceUuid] = adsPointer
-    adsPointer.lastTimer = s.advertiseTimer(adsPointer, 1 * time.Second, adsPointer.MaxAge)
-    adsPointer.last3sTimer = s.advertiseTimer(adsPointer, 3 * time.Second, adsPointer.MaxAge)
+// Creates a new server that logs to lg from Info up.
+// For packet tracing, use NewSsdpWithSlog with NewLoggerHandler(lg, LevelTrace).
+func NewSsdpWithLogger(l SsdpListener, lg LoggerInterface) (*Ssdp, error) {
+    return NewSsdpWithSlog(l, slog.New(NewLoggerHandler(lg, slog.LevelInfo)))
 }
 
-
-func (s *Ssdp) RemoveServer(deviceUuid string) {
-    s.interactionLock.Lock()
-    defer s.interactionLock.Unlock()
-    if !s.isRunning {
-        return
+// Creates a new server that logs to lg, with source, st, usn, interface and direction attributes.
+func NewSsdpWithSlog(l SsdpListener, lg *slog.Logger) (*Ssdp, error) {
+    s := newSsdp(l, lg)
+    if err := s.createSocket(); err != nil {
+        return nil, err
     }
+    s.isRunning = true
 
-
-    var ads *AdvertisableServer
-    var ok bool
-    if ads, ok = s.deviceIdToServer[deviceUuid]; !ok {
-        return
-    }
-    ads.lastTimer.Stop()
-    ads.last3sTimer.Stop()
-    delete(s.deviceIdToServer, deviceUuid)
-    var group []*AdvertisableServer
-    if group, ok = s.advertisableServers[ads.ServiceType]; !ok {
-        return
-    }
-    if len(group) == 1 {
-        delete(s.advertisableServers, ads.ServiceType)
-        return
-    }
-    for i := range group {
-        if group[i].DeviceUuid == ads.DeviceUuid {
-            newGroup := make([]*AdvertisableServer, len(group) - 1)
-            if i > 0 {
-                copy(newGroup, group[:i])
-            }
-            if i < len(group) - 1 {
-                copy(newGroup[i:], group[i+1:len(group)])
-            }
-            s.advertisableServers[ads.ServiceType] = newGroup
-            break
-        }
-    }
+    return s, nil
 }
 
-
-// Creates a new server
-func NewSsdp(l SsdpListener) (*Ssdp, error) {
-    return NewSsdpWithLogger(l, DefaultLogger{})
+// Like NewSsdpWithSlog, but lets other processes on this host bind :1900 as well, so
+// several can run side by side, eg. both ends of a Tunnel tested on loopback.
+// Multicasts reach all of them, but unicast datagrams to :1900, such as unicast
+// M-SEARCH requests, only reach one. Every process sharing the port must use it.
+func NewSharedSsdp(l SsdpListener, lg *slog.Logger) (*Ssdp, error) {
+    s := newSsdp(l, lg)
+    s.sharePort = true
+    if err := s.createSocket(); err != nil {
+        return nil, err
+    }
+    s.isRunning = true
+    return s, nil
 }
 
-func NewSsdpWithLogger(l SsdpListener, lg LoggerInterface) (*Ssdp, error) {
+// Everything but the socket.
+func newSsdp(l SsdpListener, lg *slog.Logger) *Ssdp {
     var s Ssdp
-    s.advertisableServers = make(map[string][]*AdvertisableServer)
-    s.deviceIdToServer = make(map[string]*AdvertisableServer)
+    s.devices = make(map[string]*advertisedDevice)
     s.listenSearchTargets = make(map[string]bool)
+    // BOOTID.UPNP.ORG only has to increase across restarts. Seconds since the epoch do that.
+    s.bootId = int(time.Now().Unix() & 0x7fffffff)
+    s.schedule = DefaultAdvertiseSchedule
     s.listener = l
-    s.writeChannel = make(chan writeMessage)
-    s.logger = lg
-    if err := s.createSocket(); err != nil {
-        return nil, err
+    if o, ok := l.(SearchObserver); ok {
+        s.searchObserver = o
     }
-    s.isRunning = true
-
-    return &s, nil
+    s.writeChannel = make(chan writeMessage, writeQueueSize)
+    s.logger = lg
+    s.metrics = noMetrics{}
+    return &s
 }
 
 func (s *Ssdp) parseMessage(message, hostPort string) {
     if strings.HasPrefix(message, "HTTP") {
-        if s.listener == nil {
-            return
-        }
         respData := parseResponse(message, hostPort)
-        if respData != nil {
-            s.listener.Response(*respData)
+        if respData == nil {
+            s.metrics.ParseFailure()
+            return
         }
+        s.metrics.PacketIn("RESPONSE", "")
+        s.dispatch(Event{Type: EventResponse, Source: hostPort, Response: respData})
         return
     }
     req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(message)))
     if err != nil {
-        s.logger.Warnf("Error reading request: ", err)
+        s.logger.Warn("Error reading request", logSource, hostPort, "error", err)
+        s.metrics.ParseFailure()
         return
     }
 
     if req.URL.Path != "*" {
-        s.logger.Warnf("Unknown path requested: ", req.URL.Path)
+        s.logger.Warn("Unknown path requested", logSource, hostPort, "path", req.URL.Path)
+        s.metrics.ParseFailure()
         return
     }
 
@@ -395,52 +438,39 @@ func (s *Ssdp) parseMessage(message, hostPort string) {
 
 func (s *Ssdp) parseCommand(req * http.Request, hostPort string) {
     if req.Method == "NOTIFY" {
-        s.notify(req)
+        s.notify(req, hostPort)
         return
     }
     if req.Method == "M-SEARCH" {
         s.msearch(req, hostPort)
         return
     }
-    s.logger.Warnf("Unknown message type!. Message: " + req.Method)
+    s.logger.Warn("Unknown message type", logSource, hostPort, "method", req.Method)
+    s.metrics.ParseFailure()
 }
 
-func extractUrnDeviceIdFromUsn(usn string) (deviceId, urn string) {
-    if len(usn) > 0 {
-        parts := strings.Split(usn, ":")
-        if len(parts) > 2 {
-            if parts[0] == "uuid" {
-                deviceId = parts[1]
-                urn = strings.TrimPrefix(usn, "uuid:" + deviceId + ":")
-                if parts[2] == "" {
-                    urn = strings.TrimPrefix(urn, ":")
-                }
-            } else {
-                urn = usn
-            }
-        }
-    }
-    return
-}
-
-func (s *Ssdp) notify(req * http.Request) {
-    if s.listener == nil {
-        return
-    }
+func (s *Ssdp) notify(req * http.Request, hostPort string) {
     nts := req.Header.Get("NTS")
     if nts == "" {
-        s.logger.Warnf("Missing NTS in NOTIFY")
+        s.logger.Warn("Missing NTS in NOTIFY", logSource, hostPort)
+        s.metrics.ParseFailure()
         return
     }
     searchType := req.Header.Get("NT")
     if searchType == "" {
-        s.logger.Warnf("Missing NT in NOTIFY")
+        s.logger.Warn("Missing NT in NOTIFY", logSource, hostPort)
+        s.metrics.ParseFailure()
         return
     }
-    usn := req.Header.Get("USN")
-    deviceId, urn := extractUrnDeviceIdFromUsn(usn)
+    usn := toUSN(req.Header.Get("USN"))
 
     nts = strings.ToLower(nts)
+    if nts != "ssdp:alive" && nts != "ssdp:byebye" && nts != "ssdp:update" {
+        s.logger.Warn("Could not identify NTS header", logSource, hostPort, logSt, searchType, logUsn, usn.String(), "nts", nts)
+        s.metrics.ParseFailure()
+        return
+    }
+    s.metrics.PacketIn("NOTIFY", nts)
     if nts == "ssdp:alive" {
         location := req.Header.Get("LOCATION")
         server := req.Header.Get("SERVER")
@@ -454,51 +484,43 @@ func (s *Ssdp) notify(req * http.Request) {
                 }
             }
         }
-        // don't notify alive for people we aren't listening to
-        if len(s.listenSearchTargets) > 0 {
-            if _, ok := s.listenSearchTargets[urn]; !ok {
-                return
-            }
-        }
         message := AliveMessage{
-            SearchType      : searchType,
-            DeviceId        : deviceId,
+            SearchType      : toSearchTarget(searchType),
             Usn             : usn,
-            Urn             : urn,
             Location        : location,
             MaxAge          : maxAge,
             Server          : server,
+            BootId          : headerInt(req.Header, "BOOTID.UPNP.ORG"),
+            ConfigId        : headerInt(req.Header, "CONFIGID.UPNP.ORG"),
             RawRequest      : req,
         }
-        s.listener.NotifyAlive(message)
+        message.DeviceId, message.Urn = usn.deviceIdAndUrn()
+        s.dispatch(Event{Type: EventAlive, Source: hostPort, Alive: &message})
         return
     }
     if nts == "ssdp:byebye" {
         message := ByeMessage{
-            SearchType      : searchType,
+            SearchType      : toSearchTarget(searchType),
             Usn             : usn,
-            Urn             : urn,
-            DeviceId        : deviceId,
+            BootId          : headerInt(req.Header, "BOOTID.UPNP.ORG"),
+            ConfigId        : headerInt(req.Header, "CONFIGID.UPNP.ORG"),
             RawRequest      : req,
         }
-        s.listener.NotifyBye(message)
+        message.DeviceId, message.Urn = usn.deviceIdAndUrn()
+        s.dispatch(Event{Type: EventBye, Source: hostPort, Bye: &message})
         return
     }
-    s.logger.Warnf("Could not identify NTS header!: " + nts)
-}
-
-
-func (s *Ssdp) msearch(req * http.Request, hostPort string) {
-    if v := req.Header.Get("MAN"); v == "" {
-        return
-    }
-    if v := req.Header.Get("MX"); v == "" {
-        return
-    }
-    if st := req.Header.Get("ST"); st == "" {
-        return
-    } else {
-        s.inMSearch(st, req, hostPort) // TODO: extract MX
+    if nts == "ssdp:update" {
+        message := UpdateMessage{
+            SearchType      : toSearchTarget(searchType),
+            Usn             : usn,
+            Location        : req.Header.Get("LOCATION"),
+            BootId          : headerInt(req.Header, "BOOTID.UPNP.ORG"),
+            NextBootId      : headerInt(req.Header, "NEXTBOOTID.UPNP.ORG"),
+            ConfigId        : headerInt(req.Header, "CONFIGID.UPNP.ORG"),
+            RawRequest      : req,
+        }
+        s.dispatch(Event{Type: EventUpdate, Source: hostPort, Update: &message})
     }
 }
 
@@ -520,89 +542,23 @@ func parseResponse(msg, hostPort string) (*ResponseMessage) {
             }
         }
     }
-    usn := resp.Header.Get("USN")
-    deviceId, urn := extractUrnDeviceIdFromUsn(usn)
-
     respMessage := ResponseMessage{
         MaxAge              : maxAge,
-        SearchType          : resp.Header.Get("ST"),
-        Usn                 : usn,
-        Urn                 : urn,
-        DeviceId            : deviceId,
+        SearchType          : toSearchTarget(resp.Header.Get("ST")),
+        Usn                 : toUSN(resp.Header.Get("USN")),
         Location            : resp.Header.Get("LOCATION"),
         Server              : resp.Header.Get("SERVER"),
+        BootId              : headerInt(resp.Header, "BOOTID.UPNP.ORG"),
+        ConfigId            : headerInt(resp.Header, "CONFIGID.UPNP.ORG"),
         RawResponse         : resp,
     }
+    respMessage.DeviceId, respMessage.Urn = respMessage.Usn.deviceIdAndUrn()
     return &respMessage
 }
 
 
-func (s *Ssdp) inMSearch(st string, req * http.Request, sendTo string) {
-    if st[0] == '"' && st[len(st) - 1] == '"' {
-        st = st[1:len(st) - 2]
-    }
-    mx := 1
-    if mxStr := req.Header.Get("MX"); mxStr != "" {
-        mxInt64, err := strconv.ParseInt(mxStr, 10, 0)
-        if err != nil {
-            mx = int(mxInt64)
-        }
-        if mx < 1 {
-            mx = 1
-        } else if mx > 5 {
-            mx = 5
-        }
-    }
-
-    if st == "ssdp:all" {
-        for _, v := range s.advertisableServers {
-            for _, d := range v {
-                s.respondToMSearch(d, sendTo, mx)
-            }
-        }
-    } else if d, ok := s.deviceIdToServer[st]; ok {
-        s.respondToMSearch(d, sendTo, mx)
-    } else if v, ok := s.advertisableServers[st]; ok {
-        for _, d := range v {
-            s.respondToMSearch(d, sendTo, mx)
-        }
-    }
-}
-
-func (s *Ssdp) respondToMSearch(ads *AdvertisableServer, sendTo string, mx int) {
-    time.Sleep(time.Duration(mx) * time.Second)
-
-
-    msg := createSsdpHeader(
-        "200 OK",
-        map[string]string{
-            "ST": ads.ServiceType,
-            "USN": ads.usn,
-            "LOCATION": ads.Location,
-            "CACHE-CONTROL": fmt.Sprintf("max-age=%d", ads.MaxAge),
-            "DATE": time.Now().Format(time.RFC1123),
-            "SERVER": serverName,
-            "EXT": "",
-        },
-        true,
-    )
-
-    addr, err := net.ResolveUDPAddr("udp4", sendTo)
-    if err != nil {
-        s.logger.Errorf("Error resolving UDP addr: ", err)
-        return
-    }
-
-    s.interactionLock.Lock()
-    defer s.interactionLock.Unlock()
-    if !s.isRunning  {
-        return
-    }
-
-    s.writeChannel <- writeMessage{msg, addr, false}
-}
-
-// Filters the NOTIFIES to only be returned for the given target.
+// Filters the NOTIFIES and responses given to the listener and the Events channel
+// to only be returned for the given target. See Subscribe for finer filters.
 func (s *Ssdp) ListenFor(searchTarget string) error {
     s.interactionLock.Lock()
     defer s.interactionLock.Unlock()
@@ -616,83 +572,44 @@ func (s *Ssdp) ListenFor(searchTarget string) error {
     return nil
 }
 
-
-func (s *Ssdp) advertiseTimer(ads *AdvertisableServer, d time.Duration, age int) *time.Timer {
-    var timer *time.Timer
-    timer = time.AfterFunc(d, func () {
-        s.advertiseServer(ads, true)
-        timer.Reset(d + time.Duration(age) * time.Second)
-    })
-    return timer
-}
-
-
-// Kills the server by closing the socket.
-// If any servers are being advertised they will NOTIFY a byebye
-func (s *Ssdp) Stop() {
+// True if NOTIFYs for the target should be reported.
+func (s *Ssdp) isListeningFor(target string) bool {
     s.interactionLock.Lock()
-    s.isRunning = false
-    s.interactionLock.Unlock()
-
-    if s.socket.IsValid() {
-        if len(s.advertisableServers) > 0 {
-            s.advertiseClosed()
-        }
-        s.writeChannel <- writeMessage{nil, nil, true}
-        s.exitWriteWaitGroup.Wait()
-        close(s.writeChannel)
-        //s.socket.Close()
-        s.closeSocket()
-        //s.rawSocket.Close()
-        s.exitReadWaitGroup.Wait()
-        //s.rawSocket = nil
-    }
-    s.logger.Tracef("Stop exiting")
+    defer s.interactionLock.Unlock()
+    return len(s.listenSearchTargets) == 0 || s.listenSearchTargets[target]
 }
 
-func (s *Ssdp) advertiseClosed() {
-    for _, ad := range s.deviceIdToServer {
-        ad.lastTimer.Stop()
-        ad.last3sTimer.Stop()
-        s.advertiseServer(ad, false)
-    }
+// Removes a target added by ListenFor.
+// Once no targets are left, every NOTIFY is reported again.
+func (s *Ssdp) StopListeningFor(searchTarget string) {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+    delete(s.listenSearchTargets, searchTarget)
 }
 
-func (s *Ssdp) advertiseServer(ads *AdvertisableServer, alive bool) {
+// The targets added by ListenFor, sorted.
+func (s *Ssdp) ListenTargets() []string {
     s.interactionLock.Lock()
     defer s.interactionLock.Unlock()
-
-    if !s.isRunning  {
-        return
+    targets := make([]string, 0, len(s.listenSearchTargets))
+    for st := range s.listenSearchTargets {
+        targets = append(targets, st)
     }
+    sort.Strings(targets)
+    return targets
+}
 
-    ntsString := "ssdp:alive"
-    if !alive {
-        ntsString = "ssdp:byebye"
-    }
 
-    heads := map[string]string{
-        "HOST": "239.255.255.250:1900",
-        "NT": ads.ServiceType,
-        "NTS": ntsString,
-        "USN": ads.usn,
-    }
-    if alive {
-        heads["LOCATION"] = ads.Location
-        heads["CACHE-CONTROL"] = fmt.Sprintf("max-age=%d", ads.MaxAge)
-        heads["SERVER"] = serverName
-    }
-    msg := createSsdpHeader(
-            "NOTIFY",
-            heads,
-            false,
-        )
-
-    to, err := net.ResolveUDPAddr("udp4", "239.255.255.250:1900")
-    if err == nil {
-        s.writeChannel <- writeMessage{msg, to, false}
-    } else {
-        s.logger.Warnf("Error sending advertisement: ", err)
+// Kills the server by closing the socket.
+// If any servers are being advertised they will NOTIFY a byebye.
+// Gives up on unsent messages after 5 seconds. See Shutdown for more control.
+//
+// Deprecated: cancel the context given to Run, or call Shutdown.
+func (s *Ssdp) Stop() {
+    ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+    defer cancel()
+    if err := s.Shutdown(ctx); err != nil {
+        s.logger.Warn("Error stopping", "error", err)
     }
 }
 
@@ -710,44 +627,51 @@ func createSsdpHeader(head string, vars map[string]string, isResponse bool) []by
     return []byte(buf.String())
 }
 
-// Starts listening to packets on the network.
+// Starts listening to packets on the network. Blocks until Stop is called.
+//
+// Deprecated: use Run, which also reports why it stopped.
 func (s *Ssdp) Start() {
-    go s.socketWriter()
-    s.socketReader()
+    if err := s.Run(context.Background()); err != nil {
+        s.logger.Warn("Stopped running", "error", err)
+    }
 }
 
 
-func (s *Ssdp) socketReader() {
-    s.exitReadWaitGroup.Add(1)
-    defer s.exitReadWaitGroup.Add(-1)
+// Reads until the socket fails or is closed.
+func (s *Ssdp) socketReader() error {
+    defer s.exitReadWaitGroup.Done()
 
     for {
-        msg, src, err := s.read()
+        p, err := s.readPacket()
         if err != nil {
-            s.logger.Warnf("Error reading from SSDP socket: %v", err)
-            return
+            return err
         }
-        if len(msg) > 0 {
-            //s.logger.Warnf("Received: %s", string(msg))
-            s.parseMessage(string(msg), src)
-            //s.logger.Warnf("Done parsing")
+        if len(p.Data) > 0 {
+            trace(s.logger, "Received message", logSource, logAddr{p.Source}, logInterface, p.Interface, logDirection, "in", "bytes", len(p.Data))
+            s.reading = p
+            s.parseMessage(string(p.Data), p.Source.String())
+            s.reading = Packet{}
         }
     }
 }
 
 func (s *Ssdp) socketWriter() {
-    s.exitWriteWaitGroup.Add(1)
-    defer s.exitWriteWaitGroup.Add(-1)
+    defer s.exitWriteWaitGroup.Done()
     for {
         msg, more := <- s.writeChannel
         if !more {
             return
         }
-        if msg.shouldExit {
-            return
+        err := s.writePacket(msg)
+        if err != nil {
+            s.logger.Warn("Error sending message", logDestination, msg.to.String(), logDirection, "out", "error", err)
+            s.metrics.WriteError()
+        } else {
+            trace(s.logger, "Sent message", logDestination, logAddr{msg.to}, logDirection, "out", "bytes", len(msg.message))
+            countSent(s.metrics, msg)
         }
-        if err := s.write(msg); err != nil {
-            s.logger.Warnf("Error sending message. %v", err)
+        if msg.sent != nil {
+            msg.sent(err)
         }
     }
 }
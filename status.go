@@ -0,0 +1,75 @@
+package gossdp
+
+import (
+    "sort"
+    "time"
+)
+
+
+// What we know about sending one server's advertisements. Guarded by Ssdp.statusLock
+type serverStatus struct {
+    lastSent            time.Time
+    sendErrors          int
+    lastError           error
+}
+
+// A server we advertise, and how its advertisements are going.
+type ServerStatus struct {
+    // The server, as it is currently advertised
+    Server              AdvertisableServer
+    // When a NOTIFY for this server was last sent. Zero if none went out yet
+    LastSent            time.Time
+    // When the next periodic ssdp:alive is due
+    NextScheduled       time.Time
+    // How many of its messages failed to send
+    SendErrors          int
+    // The most recent send failure. nil if there was none
+    LastError           error
+}
+
+// Lists the servers being advertised, ordered by device UUID.
+func (s *Ssdp) Servers() []ServerStatus {
+    s.interactionLock.Lock()
+    defer s.interactionLock.Unlock()
+
+    uuids := make([]string, 0, len(s.devices))
+    for uuid := range s.devices {
+        uuids = append(uuids, uuid)
+    }
+    sort.Strings(uuids)
+
+    s.statusLock.Lock()
+    defer s.statusLock.Unlock()
+    var servers []ServerStatus
+    for _, uuid := range uuids {
+        d := s.devices[uuid]
+        for _, ads := range d.services {
+            server := *ads
+            server.ExtraHeaders = copyExtraHeaders(ads.ExtraHeaders)
+            server.status = nil
+            servers = append(servers, ServerStatus{
+                Server              : server,
+                LastSent            : ads.status.lastSent,
+                NextScheduled       : d.nextAdvertise,
+                SendErrors          : ads.status.sendErrors,
+                LastError           : ads.status.lastError,
+            })
+        }
+    }
+    return servers
+}
+
+// Returns the callback the writer uses to report how sending a message for ads went.
+func (s *Ssdp) recordSend(ads *AdvertisableServer) func(err error) {
+    status := ads.status
+    return func (err error) {
+        s.statusLock.Lock()
+        defer s.statusLock.Unlock()
+        if err != nil {
+            status.sendErrors++
+            status.lastError = err
+            return
+        }
+        status.lastSent = time.Now()
+    }
+}
@@ -0,0 +1,216 @@
+package gossdp
+
+import (
+    "net"
+    "regexp"
+    "strings"
+    "sync"
+)
+
+
+// Selects the events a subscriber receives. Every field that is set must match.
+// The zero Filter matches every alive, byebye, update and response event.
+type Filter struct {
+    // The event types to receive. Empty means alive, byebye, update and response.
+    //  Add EventSearch to also see M-SEARCH requests
+    Types               []EventType
+    // NT or ST values, compared exactly. Eg. urn:schemas-upnp-org:device:MediaServer:1
+    SearchTargets       []string
+    // The start of the USN. Eg. uuid:2fac1234-31f8-11b4-a222-08002b34c003
+    UsnPrefix           string
+    // Networks the message must come from
+    Sources             []*net.IPNet
+    // Must match the SERVER header. Byebye and update messages have none, so never match
+    Server              *regexp.Regexp
+    // Any other test
+    Match               func(Event) bool
+}
+
+var defaultFilterTypes = []EventType{EventAlive, EventBye, EventUpdate, EventResponse}
+
+// True if the event passes every test of the filter.
+func (f Filter) Matches(ev Event) bool {
+    types := f.Types
+    if len(types) == 0 {
+        types = defaultFilterTypes
+    }
+    if !containsType(types, ev.Type) {
+        return false
+    }
+    if len(f.SearchTargets) > 0 && !containsString(f.SearchTargets, ev.SearchType().String()) {
+        return false
+    }
+    if f.UsnPrefix != "" && !strings.HasPrefix(ev.Usn().String(), f.UsnPrefix) {
+        return false
+    }
+    if len(f.Sources) > 0 && !inNetworks(f.Sources, ev.Source) {
+        return false
+    }
+    if f.Server != nil && !f.Server.MatchString(ev.Server()) {
+        return false
+    }
+    if f.Match != nil && !f.Match(ev) {
+        return false
+    }
+    return true
+}
+
+// The NT of a NOTIFY, or the ST of a response or search.
+func (ev Event) SearchType() SearchTarget {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.SearchType
+    case EventBye:
+        return ev.Bye.SearchType
+    case EventUpdate:
+        return ev.Update.SearchType
+    case EventResponse:
+        return ev.Response.SearchType
+    case EventSearch:
+        return ev.Search.SearchType
+    }
+    return SearchTarget{}
+}
+
+// The USN of the message. Zero for searches.
+func (ev Event) Usn() USN {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.Usn
+    case EventBye:
+        return ev.Bye.Usn
+    case EventUpdate:
+        return ev.Update.Usn
+    case EventResponse:
+        return ev.Response.Usn
+    }
+    return USN{}
+}
+
+// The SERVER of an alive or response. Empty for other events.
+func (ev Event) Server() string {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.Server
+    case EventResponse:
+        return ev.Response.Server
+    }
+    return ""
+}
+
+// The LOCATION of an alive, update or response. Empty for other events.
+func (ev Event) Location() string {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.Location
+    case EventUpdate:
+        return ev.Update.Location
+    case EventResponse:
+        return ev.Response.Location
+    }
+    return ""
+}
+
+// The max-age of an alive or response. -1 for other events, or when it was not sent.
+func (ev Event) MaxAge() int {
+    switch ev.Type {
+    case EventAlive:
+        return ev.Alive.MaxAge
+    case EventResponse:
+        return ev.Response.MaxAge
+    }
+    return -1
+}
+
+func containsType(types []EventType, t EventType) bool {
+    for _, v := range types {
+        if v == t {
+            return true
+        }
+    }
+    return false
+}
+
+func containsString(values []string, s string) bool {
+    for _, v := range values {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
+
+func inNetworks(networks []*net.IPNet, hostPort string) bool {
+    host, _, err := net.SplitHostPort(hostPort)
+    if err != nil {
+        host = hostPort
+    }
+    ip := net.ParseIP(host)
+    if ip == nil {
+        return false
+    }
+    for _, n := range networks {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+
+type subscriber struct {
+    filter              Filter
+    handler             func(Event)
+}
+
+// The subscribers of an Ssdp or ClientSsdp.
+type subscriberList struct {
+    lock                sync.Mutex
+    nextId              int
+    subs                map[int]subscriber
+}
+
+func (l *subscriberList) add(filter Filter, handler func(Event)) func() {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    if l.subs == nil {
+        l.subs = make(map[int]subscriber)
+    }
+    id := l.nextId
+    l.nextId++
+    l.subs[id] = subscriber{filter, handler}
+    var once sync.Once
+    return func () {
+        once.Do(func () {
+            l.lock.Lock()
+            defer l.lock.Unlock()
+            delete(l.subs, id)
+        })
+    }
+}
+
+func (l *subscriberList) publish(ev Event) {
+    l.lock.Lock()
+    subs := make([]subscriber, 0, len(l.subs))
+    for _, sub := range l.subs {
+        subs = append(subs, sub)
+    }
+    l.lock.Unlock()
+    for _, sub := range subs {
+        if sub.filter.Matches(ev) {
+            sub.handler(ev)
+        }
+    }
+}
+
+// Calls handler for every event that passes filter, until unsubscribe is called.
+// Subscribers are independent of ListenFor and of each other. Handlers run on the
+// reader goroutine, like SsdpListener, so they should return quickly.
+func (s *Ssdp) Subscribe(filter Filter, handler func(Event)) (unsubscribe func()) {
+    return s.subscribers.add(filter, handler)
+}
+
+// Like Ssdp.Subscribe. The client only receives responses.
+func (c *ClientSsdp) Subscribe(filter Filter, handler func(Event)) (unsubscribe func()) {
+    return c.subscribers.add(filter, handler)
+}
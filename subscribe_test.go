@@ -0,0 +1,106 @@
+package gossdp
+
+import (
+    "net"
+    "regexp"
+    "testing"
+    "time"
+)
+
+
+func testEvent(t EventType, source string) Event {
+    st := DeviceTarget("schemas-upnp-org", "MediaServer", 1)
+    usn := NewUSN(testDeviceUuid, st)
+    ev := Event{Type: t, Source: source}
+    switch t {
+    case EventAlive:
+        ev.Alive = &AliveMessage{SearchType: st, Usn: usn, Server: "Linux/6.1 UPnP/2.0 Test/1.0"}
+    case EventBye:
+        ev.Bye = &ByeMessage{SearchType: st, Usn: usn}
+    case EventSearch:
+        ev.Search = &SearchMessage{SearchType: st}
+    }
+    return ev
+}
+
+func TestFilterMatches(t *testing.T) {
+    _, lan, _ := net.ParseCIDR("10.0.0.0/24")
+    _, v6, _ := net.ParseCIDR("fd00::/8")
+    tests := []struct {
+        name            string
+        filter          Filter
+        ev              Event
+        want            bool
+    }{
+        {"zero, alive", Filter{}, testEvent(EventAlive, "10.0.0.7:1900"), true},
+        {"zero, byebye", Filter{}, testEvent(EventBye, "10.0.0.7:1900"), true},
+        {"zero, search", Filter{}, testEvent(EventSearch, "10.0.0.7:1900"), false},
+        {"types, search", Filter{Types: []EventType{EventSearch}}, testEvent(EventSearch, "10.0.0.7:1900"), true},
+        {"types, alive", Filter{Types: []EventType{EventSearch, EventBye}}, testEvent(EventAlive, "10.0.0.7:1900"), false},
+        {"st", Filter{SearchTargets: []string{"upnp:rootdevice", "urn:schemas-upnp-org:device:MediaServer:1"}}, testEvent(EventAlive, "10.0.0.7:1900"), true},
+        {"other st", Filter{SearchTargets: []string{"urn:schemas-upnp-org:device:MediaServer:2"}}, testEvent(EventAlive, "10.0.0.7:1900"), false},
+        {"st of a search", Filter{Types: []EventType{EventSearch}, SearchTargets: []string{"urn:schemas-upnp-org:device:MediaServer:1"}}, testEvent(EventSearch, "10.0.0.7:1900"), true},
+        {"usn prefix", Filter{UsnPrefix: "uuid:" + testDeviceUuid}, testEvent(EventBye, "10.0.0.7:1900"), true},
+        {"other usn prefix", Filter{UsnPrefix: "uuid:0"}, testEvent(EventBye, "10.0.0.7:1900"), false},
+        {"source", Filter{Sources: []*net.IPNet{v6, lan}}, testEvent(EventAlive, "10.0.0.7:1900"), true},
+        {"source, v6", Filter{Sources: []*net.IPNet{v6, lan}}, testEvent(EventAlive, "[fd00::7]:1900"), true},
+        {"source, no port", Filter{Sources: []*net.IPNet{lan}}, testEvent(EventAlive, "10.0.0.7"), true},
+        {"other source", Filter{Sources: []*net.IPNet{lan}}, testEvent(EventAlive, "10.0.1.7:1900"), false},
+        {"bad source", Filter{Sources: []*net.IPNet{lan}}, testEvent(EventAlive, "printer:1900"), false},
+        {"server", Filter{Server: regexp.MustCompile(`Test/1\.`)}, testEvent(EventAlive, "10.0.0.7:1900"), true},
+        {"server, byebye", Filter{Server: regexp.MustCompile(`Test`)}, testEvent(EventBye, "10.0.0.7:1900"), false},
+        {"match", Filter{Match: func(ev Event) bool { return ev.Source == "10.0.0.7:1900" }}, testEvent(EventAlive, "10.0.0.7:1900"), true},
+        {"all but one", Filter{
+            Types               : []EventType{EventAlive},
+            SearchTargets       : []string{"urn:schemas-upnp-org:device:MediaServer:1"},
+            Sources             : []*net.IPNet{lan},
+            Match               : func(Event) bool { return false },
+        }, testEvent(EventAlive, "10.0.0.7:1900"), false},
+    }
+    for _, tt := range tests {
+        if got := tt.filter.Matches(tt.ev); got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestSubscribe(t *testing.T) {
+    var l subscriberList
+    var alive, all int
+    unsubscribe := l.add(Filter{Types: []EventType{EventAlive}}, func (Event) { alive++ })
+    l.add(Filter{}, func (Event) { all++ })
+    l.publish(testEvent(EventAlive, "10.0.0.7:1900"))
+    l.publish(testEvent(EventBye, "10.0.0.7:1900"))
+    unsubscribe()
+    unsubscribe()
+    l.publish(testEvent(EventAlive, "10.0.0.7:1900"))
+    if alive != 1 || all != 3 {
+        t.Errorf("got %d alive and %d in all, want 1 and 3", alive, all)
+    }
+}
+
+func TestUnsubscribeInHandler(t *testing.T) {
+    var l subscriberList
+    var calls int
+    var unsubscribe func()
+    unsubscribe = l.add(Filter{}, func (Event) {
+        calls++
+        unsubscribe()
+        // and subscribe another from the handler too
+        l.add(Filter{}, func (Event) {})
+    })
+    done := make(chan struct{})
+    go func () {
+        defer close(done)
+        l.publish(testEvent(EventAlive, "10.0.0.7:1900"))
+        l.publish(testEvent(EventAlive, "10.0.0.7:1900"))
+    }()
+    select {
+    case <- done:
+    case <- time.After(2 * time.Second):
+        t.Fatal("deadlocked unsubscribing in a handler")
+    }
+    if calls != 1 {
+        t.Errorf("handler called %d times, want 1", calls)
+    }
+}
@@ -0,0 +1,586 @@
+package gossdp
+
+import (
+    "bufio"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+
+// How many messages may wait for the tunnel peer before new ones are dropped.
+const tunnelQueueSize = 64
+
+// The largest frame sent or taken over a tunnel, so one fits a UDP datagram.
+// Anything bigger from the peer is refused before it is decoded.
+const maxTunnelFrame = 65507
+
+// How far the time of a signed frame may be from ours before it is taken for a replay.
+const maxFrameSkew = 5 * time.Minute
+
+// How often each end says hello, telling the peer the nonce to sign its frames with.
+const helloInterval = 30 * time.Second
+
+// How long a stream peer has to say hello before it is dropped.
+const handshakeTimeout = 10 * time.Second
+
+// Bridges discovery with a peer at another site, over a TCP connection or UDP.
+//
+// NOTIFY messages and multicast M-SEARCH requests heard by the Ssdp are sent to the
+// peer, which multicasts them on its own network. Responses to a tunnelled search
+// travel back the same way, and are sent on to the original searcher. LOCATION can
+// be rewritten as messages come out of the tunnel, eg. to point at a reverse proxy.
+// See SetRewriteLocation.
+//
+// Tunnelled messages carry the same X-SSDP-RELAY header as Relay uses, so a message
+// never goes round a loop of tunnels and relays.
+//
+// The link is not authenticated unless SetSecret is called, or the peer limited with
+// SetAllowedPeers. Without them, whoever reaches the tunnel first can multicast any
+// NOTIFY or M-SEARCH onto our network. Either way the link is not encrypted.
+//
+// Each end starts by saying hello, with a random nonce, and repeats it every 30 seconds.
+// With a secret, every other frame is signed for the nonce of the end it goes to and
+// carries a sequence number, so frames can neither be replayed nor taken to another
+// connection.
+type Tunnel struct {
+    s                   *Ssdp
+    conn                tunnelConn
+    id                  string
+    outgoing            chan tunnelFrame
+    rewrite             func(location string) string
+    filter              Filter
+    maxHops             int
+    secret              []byte
+    allowed             []*net.IPNet
+    // ours, sent in hello frames
+    nonce               []byte
+    // the sequence number of the last frame sent. Only the send loop uses it
+    seq                 uint64
+    // the sequence number of the last frame taken. Only the receive loop uses it
+    lastSeq             uint64
+    lock                sync.Mutex
+    // the peer's nonce, from its last hello. Guarded by lock
+    peerNonce           []byte
+    // searches the peer sent us, whose responses go back to it. Guarded by lock
+    searches            []relayedSearch
+    // searchers we sent the peer, and when we stop taking responses for them. Guarded by lock
+    searchers           map[string]time.Time
+}
+
+// What goes through the tunnel.
+type tunnelFrame struct {
+    // hello, notify, search or response
+    Kind                string          `json:"kind"`
+    // For searches, the host:port of the searcher. For responses, where to send it
+    Searcher            string          `json:"searcher,omitempty"`
+    // The SSDP message
+    Message             []byte          `json:"message,omitempty"`
+    // For hellos, the sender's nonce, and whether it answers the peer's hello
+    Nonce               []byte          `json:"nonce,omitempty"`
+    Reply               bool            `json:"reply,omitempty"`
+    // When it was sent, in unix seconds, its sequence number and its HMAC-SHA256. Only with SetSecret
+    Time                int64           `json:"time,omitempty"`
+    Seq                 uint64          `json:"seq,omitempty"`
+    Mac                 []byte          `json:"mac,omitempty"`
+
+    // where a packet tunnel sends it, when not to the peer
+    to                  net.Addr
+}
+
+// Tunnels what s receives over conn, a stream such as a TCP connection.
+// Messages are sent as JSON, one per line. The peer must say hello within 10 seconds.
+func NewTunnel(s *Ssdp, conn net.Conn) *Tunnel {
+    sc := bufio.NewScanner(conn)
+    // room for the newline too
+    sc.Buffer(make([]byte, 4096), maxTunnelFrame + 1)
+    return newTunnel(s, &streamTunnel{conn: conn, scanner: sc})
+}
+
+// Tunnels what s receives as UDP datagrams on conn, each holding one JSON message.
+// peer is where they are sent. If it is nil, the first sender we accept becomes the
+// peer for good, so one side can listen while the other dials. With a secret, that is
+// the first to send a signed frame other than a hello, as hellos can be replayed.
+func NewPacketTunnel(s *Ssdp, conn net.PacketConn, peer net.Addr) *Tunnel {
+    return newTunnel(s, &packetTunnel{conn: conn, peer: peer, buf: make([]byte, maxTunnelFrame)})
+}
+
+func newTunnel(s *Ssdp, conn tunnelConn) *Tunnel {
+    id := make([]byte, 4)
+    rand.Read(id)
+    nonce := make([]byte, 16)
+    rand.Read(nonce)
+    return &Tunnel{
+        s                   : s,
+        conn                : conn,
+        id                  : hex.EncodeToString(id),
+        nonce               : nonce,
+        outgoing            : make(chan tunnelFrame, tunnelQueueSize),
+        filter              : Filter{Types: relayTypes},
+        maxHops             : defaultRelayHops,
+        searchers           : make(map[string]time.Time),
+    }
+}
+
+// Signs every frame with secret, and drops frames from the peer that are not signed
+// with it, are more than 5 minutes old, or were seen before. Both ends must use the same secret.
+// Must be called before Run.
+func (t *Tunnel) SetSecret(secret []byte) {
+    t.secret = secret
+}
+
+// Only takes frames from peers in these networks. Must be called before Run.
+func (t *Tunnel) SetAllowedPeers(networks []*net.IPNet) {
+    t.allowed = networks
+}
+
+// Rewrites the LOCATION of messages coming out of the tunnel. See ProxyLocation.
+// Must be called before Run.
+func (t *Tunnel) SetRewriteLocation(rewrite func(location string) string) {
+    t.rewrite = rewrite
+}
+
+// Only sends the peer what passes f. A filter without Types applies to every kind of message.
+// Must be called before Run.
+func (t *Tunnel) SetFilter(f Filter) {
+    if len(f.Types) == 0 {
+        f.Types = relayTypes
+    }
+    t.filter = f
+}
+
+// Sets how many relays and tunnels a message may pass through. Must be called before Run.
+func (t *Tunnel) SetMaxHops(n int) {
+    t.maxHops = n
+}
+
+// The name other relays and tunnels see in X-SSDP-RELAY.
+func (t *Tunnel) Id() string {
+    return t.id
+}
+
+// Returns a rewrite for SetRewriteLocation that sends description requests through a
+// reverse proxy. http://10.0.0.5:49152/desc.xml becomes base/10.0.0.5:49152/desc.xml
+func ProxyLocation(base string) func(location string) string {
+    base = strings.TrimSuffix(base, "/")
+    return func (location string) string {
+        u, err := url.Parse(location)
+        if err != nil || u.Host == "" {
+            return location
+        }
+        return base + "/" + u.Host + u.RequestURI()
+    }
+}
+
+// Tunnels until ctx is done or the connection fails. The Ssdp must be run separately.
+// Returns nil once ctx is done, otherwise why the tunnel stopped.
+// The connection is closed when Run returns.
+func (t *Tunnel) Run(ctx context.Context) error {
+    runCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    unsubscribe := t.s.Subscribe(Filter{Types: relayTypes}, t.forward)
+    defer unsubscribe()
+    go func () {
+        <- runCtx.Done()
+        t.conn.Close()
+    }()
+    if st, ok := t.conn.(*streamTunnel); ok {
+        // cleared by the peer's hello
+        st.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+    }
+    sendErr := make(chan error, 1)
+    go func () {
+        sendErr <- t.sendLoop(runCtx)
+    }()
+
+    recvErr := t.receiveLoop()
+    cancel()
+    err := <- sendErr
+    if ctx.Err() != nil {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("Error sending to tunnel peer: %w", err)
+    }
+    return fmt.Errorf("Error reading from tunnel peer: %w", recvErr)
+}
+
+func (t *Tunnel) sendLoop(ctx context.Context) error {
+    hello := time.NewTicker(helloInterval)
+    defer hello.Stop()
+    err := t.write(tunnelFrame{Kind: "hello"})
+    for err == nil {
+        select {
+        case <- ctx.Done():
+            return nil
+        case <- hello.C:
+            err = t.write(tunnelFrame{Kind: "hello"})
+        case f := <- t.outgoing:
+            err = t.write(f)
+        }
+    }
+    // stops the receive loop too
+    t.conn.Close()
+    return err
+}
+
+// Seals and sends a frame. Only the send loop writes.
+func (t *Tunnel) write(f tunnelFrame) error {
+    if f.Kind == "hello" {
+        f.Nonce = t.nonce
+    }
+    if t.secret != nil {
+        // hellos are signed for nobody, as the peer's nonce may not be known yet
+        var nonce []byte
+        if f.Kind != "hello" {
+            t.lock.Lock()
+            nonce = t.peerNonce
+            t.lock.Unlock()
+            if nonce == nil {
+                // the peer has not said hello, so it could not check the frame
+                return nil
+            }
+        }
+        now := time.Now()
+        f.Time = now.Unix()
+        // from the clock, so it keeps rising when we restart
+        f.Seq = uint64(now.UnixNano())
+        if f.Seq <= t.seq {
+            f.Seq = t.seq + 1
+        }
+        t.seq = f.Seq
+        f.Mac = t.sign(f, nonce)
+    }
+    return t.conn.send(f)
+}
+
+func (t *Tunnel) receiveLoop() error {
+    for {
+        f, from, err := t.conn.receive()
+        if err != nil {
+            return err
+        }
+        if !t.accept(f, from) {
+            if _, ok := t.conn.(*streamTunnel); ok {
+                // a stream has only one sender, and it isn't our peer
+                return fmt.Errorf("Tunnel peer %s failed authentication", from)
+            }
+            t.s.logger.Debug("Dropping tunnel message", logSource, from.String(), "kind", f.Kind)
+            continue
+        }
+        if f.Kind == "hello" {
+            t.hello(f, from)
+            continue
+        }
+        t.emit(f)
+    }
+}
+
+// Checks a frame came from the peer, and is not a replay.
+func (t *Tunnel) accept(f tunnelFrame, from net.Addr) bool {
+    if len(t.allowed) > 0 && !inNetworks(t.allowed, from.String()) {
+        return false
+    }
+    if t.secret != nil {
+        var nonce []byte
+        if f.Kind != "hello" {
+            nonce = t.nonce
+        }
+        if !hmac.Equal(f.Mac, t.sign(f, nonce)) {
+            return false
+        }
+        skew := time.Since(time.Unix(f.Time, 0))
+        if skew > maxFrameSkew || skew < -maxFrameSkew || f.Seq <= t.lastSeq {
+            return false
+        }
+    }
+    if pt, ok := t.conn.(*packetTunnel); ok {
+        if t.secret != nil && f.Kind == "hello" {
+            // an old hello can be replayed by anyone, so only fresh frames pin the peer
+            if !pt.couldBePeer(from) {
+                return false
+            }
+        } else if !pt.pin(from) {
+            return false
+        }
+    }
+    t.lastSeq = f.Seq
+    return true
+}
+
+// Takes the peer's nonce, answering its hello with ours.
+func (t *Tunnel) hello(f tunnelFrame, from net.Addr) {
+    if len(f.Nonce) == 0 {
+        return
+    }
+    t.lock.Lock()
+    t.peerNonce = f.Nonce
+    t.lock.Unlock()
+    if st, ok := t.conn.(*streamTunnel); ok {
+        st.conn.SetReadDeadline(time.Time{})
+    }
+    if !f.Reply {
+        t.send(tunnelFrame{Kind: "hello", Reply: true, to: from})
+    }
+}
+
+// The HMAC of a frame, for the end whose nonce is given.
+func (t *Tunnel) sign(f tunnelFrame, nonce []byte) []byte {
+    mac := hmac.New(sha256.New, t.secret)
+    fmt.Fprintf(mac, "%s\n%s\n%d\n%d\n%x\n%t\n%x\n", f.Kind, f.Searcher, f.Time, f.Seq, f.Nonce, f.Reply, nonce)
+    mac.Write(f.Message)
+    return mac.Sum(nil)
+}
+
+// Sends what the Ssdp heard to the peer. Runs on the reader goroutine, while s.reading is the packet.
+func (t *Tunnel) forward(ev Event) {
+    chain := relayChain(rawHeader(ev))
+    for _, id := range chain {
+        if id == t.id {
+            return
+        }
+    }
+    if len(chain) >= t.maxHops || !t.filter.Matches(ev) {
+        return
+    }
+    msg := withRelayHeader(t.s.reading.Data, append(chain, t.id))
+
+    switch ev.Type {
+    case EventSearch:
+        if !ev.Search.Multicast || ev.Search.Rejected != nil {
+            return
+        }
+        wait := ev.Search.MaxWait
+        if wait > maxResponseDelay {
+            wait = maxResponseDelay
+        }
+        t.lock.Lock()
+        for searcher, expires := range t.searchers {
+            if !ev.Time.Before(expires) {
+                delete(t.searchers, searcher)
+            }
+        }
+        // allow for the search and its responses to cross the tunnel twice
+        t.searchers[ev.Source] = ev.Time.Add(time.Duration(wait + 4) * time.Second)
+        t.lock.Unlock()
+        t.send(tunnelFrame{Kind: "search", Searcher: ev.Source, Message: msg})
+    case EventResponse:
+        t.lock.Lock()
+        t.searches = expireSearches(t.searches, ev.Time)
+        var searchers []string
+        for _, search := range t.searches {
+            if relayWants(search.st, ev.Response.SearchType, ev.Response.Usn) {
+                searchers = append(searchers, search.from.String())
+            }
+        }
+        t.lock.Unlock()
+        for _, searcher := range searchers {
+            t.send(tunnelFrame{Kind: "response", Searcher: searcher, Message: msg})
+        }
+    default:
+        t.send(tunnelFrame{Kind: "notify", Message: msg})
+    }
+}
+
+// Queues a frame for the send loop, which signs it.
+func (t *Tunnel) send(f tunnelFrame) {
+    select {
+    case t.outgoing <- f:
+    default:
+        t.s.logger.Warn("Tunnel queue full. Dropping message", "kind", f.Kind)
+    }
+}
+
+// The start line each kind of frame must carry.
+var tunnelStartLines = map[string]string{
+    "notify": "NOTIFY ",
+    "search": "M-SEARCH ",
+    "response": "HTTP/1.",
+}
+
+// Sends a message from the peer out on our network.
+func (t *Tunnel) emit(f tunnelFrame) {
+    start, ok := tunnelStartLines[f.Kind]
+    if !ok || !strings.HasPrefix(string(f.Message), start) {
+        t.s.logger.Debug("Unknown tunnel message", "kind", f.Kind)
+        return
+    }
+    chain := relayChain(messageHeader(f.Message, relayHeader))
+    for _, id := range chain {
+        if id == t.id {
+            return
+        }
+    }
+    msg := withRelayHeader(f.Message, append(chain, t.id))
+    if t.rewrite != nil {
+        if location := messageHeader(msg, "LOCATION"); location != "" {
+            msg = withHeader(msg, "LOCATION", t.rewrite(location))
+        }
+    }
+    multicast := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: ssdpPort}
+
+    switch f.Kind {
+    case "notify":
+        t.s.queue(writeMessage{message: msg, to: multicast})
+    case "search":
+        from, err := net.ResolveUDPAddr("udp4", f.Searcher)
+        if err != nil {
+            return
+        }
+        wait, _ := strconv.Atoi(messageHeader(msg, "MX"))
+        if wait > maxResponseDelay {
+            wait = maxResponseDelay
+        }
+        now := time.Now()
+        t.lock.Lock()
+        t.searches = append(expireSearches(t.searches, now), relayedSearch{
+            st              : toSearchTarget(messageHeader(msg, "ST")),
+            from            : from,
+            // allow for the responses to cross the tunnel
+            expires         : now.Add(time.Duration(wait + 2) * time.Second),
+        })
+        t.lock.Unlock()
+        t.s.queue(writeMessage{message: msg, to: multicast})
+    case "response":
+        // only to someone whose search we sent, so the peer can't aim us at any host
+        t.lock.Lock()
+        expires, ok := t.searchers[f.Searcher]
+        t.lock.Unlock()
+        if !ok || !time.Now().Before(expires) {
+            t.s.logger.Debug("Dropping tunnelled response for an unknown searcher", logDestination, f.Searcher)
+            return
+        }
+        to, err := net.ResolveUDPAddr("udp4", f.Searcher)
+        if err != nil {
+            return
+        }
+        t.s.queue(writeMessage{message: msg, to: to})
+    }
+}
+
+
+type tunnelConn interface {
+    send(f tunnelFrame) error
+    // the frame, and who sent it
+    receive() (tunnelFrame, net.Addr, error)
+    Close() error
+}
+
+// Newline separated JSON over a stream. Only the send loop sends, and the receive loop receives.
+type streamTunnel struct {
+    conn                net.Conn
+    scanner             *bufio.Scanner
+}
+
+func (st *streamTunnel) send(f tunnelFrame) error {
+    data, err := json.Marshal(f)
+    if err != nil {
+        return err
+    }
+    if len(data) > maxTunnelFrame {
+        return nil
+    }
+    _, err = st.conn.Write(append(data, '\n'))
+    return err
+}
+
+func (st *streamTunnel) receive() (tunnelFrame, net.Addr, error) {
+    from := st.conn.RemoteAddr()
+    if !st.scanner.Scan() {
+        err := st.scanner.Err()
+        if err == bufio.ErrTooLong {
+            err = fmt.Errorf("Tunnel peer %s sent a frame over %d bytes", from, maxTunnelFrame)
+        } else if err == nil {
+            err = io.EOF
+        }
+        return tunnelFrame{}, from, err
+    }
+    var f tunnelFrame
+    err := json.Unmarshal(st.scanner.Bytes(), &f)
+    return f, from, err
+}
+
+func (st *streamTunnel) Close() error {
+    return st.conn.Close()
+}
+
+// One JSON message per datagram.
+type packetTunnel struct {
+    conn                net.PacketConn
+    lock                sync.Mutex
+    peer                net.Addr
+    buf                 []byte
+}
+
+func (pt *packetTunnel) send(f tunnelFrame) error {
+    pt.lock.Lock()
+    peer := pt.peer
+    pt.lock.Unlock()
+    if f.to != nil {
+        peer = f.to
+    }
+    if peer == nil {
+        // nobody to send to until the peer says hello
+        return nil
+    }
+    data, err := json.Marshal(f)
+    if err != nil {
+        return err
+    }
+    if len(data) > maxTunnelFrame {
+        return nil
+    }
+    _, err = pt.conn.WriteTo(data, peer)
+    return err
+}
+
+func (pt *packetTunnel) receive() (tunnelFrame, net.Addr, error) {
+    for {
+        n, from, err := pt.conn.ReadFrom(pt.buf)
+        if err != nil {
+            return tunnelFrame{}, nil, err
+        }
+        var f tunnelFrame
+        if err := json.Unmarshal(pt.buf[:n], &f); err != nil {
+            continue
+        }
+        return f, from, nil
+    }
+}
+
+// True if from is the peer. The first sender accepted becomes the peer when none was given.
+func (pt *packetTunnel) pin(from net.Addr) bool {
+    pt.lock.Lock()
+    defer pt.lock.Unlock()
+    if pt.peer == nil {
+        pt.peer = from
+        return true
+    }
+    return pt.peer.String() == from.String()
+}
+
+// True if from is the peer, or there is none yet.
+func (pt *packetTunnel) couldBePeer(from net.Addr) bool {
+    pt.lock.Lock()
+    defer pt.lock.Unlock()
+    if pt.peer == nil {
+        return true
+    }
+    return pt.peer.String() == from.String()
+}
+
+func (pt *packetTunnel) Close() error {
+    return pt.conn.Close()
+}
+
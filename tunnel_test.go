@@ -0,0 +1,176 @@
+package gossdp
+
+import (
+    "bytes"
+    "context"
+    "log/slog"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+
+// A tunnel whose Ssdp queues what it sends, rather than sending it.
+func testTunnel(conn net.Conn, secret string) *Tunnel {
+    s := newSsdp(nil, slog.Default())
+    s.isRunning = true
+    t := NewTunnel(s, conn)
+    if secret != "" {
+        t.SetSecret([]byte(secret))
+    }
+    return t
+}
+
+func waitQueued(t *testing.T, s *Ssdp) writeMessage {
+    select {
+    case msg := <- s.writeChannel:
+        return msg
+    case <- time.After(5 * time.Second):
+        t.Fatal("nothing came out of the tunnel")
+    }
+    return writeMessage{}
+}
+
+func waitHello(t *testing.T, tun *Tunnel) {
+    deadline := time.Now().Add(5 * time.Second)
+    for time.Now().Before(deadline) {
+        tun.lock.Lock()
+        said := tun.peerNonce != nil
+        tun.lock.Unlock()
+        if said {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatal("the peer never said hello")
+}
+
+func TestTunnelPipe(t *testing.T) {
+    for _, secret := range []string{"", "s3cret"} {
+        left, right := net.Pipe()
+        a, b := testTunnel(left, secret), testTunnel(right, secret)
+        ctx, cancel := context.WithCancel(context.Background())
+        done := make(chan error, 2)
+        go func () { done <- a.Run(ctx) }()
+        go func () { done <- b.Run(ctx) }()
+
+        waitHello(t, a)
+        a.send(tunnelFrame{Kind: "notify", Message: []byte(testNotify)})
+        msg := waitQueued(t, b.s)
+        if msg.to.String() != "239.255.255.250:1900" {
+            t.Errorf("secret %q: sent to %v, want the group", secret, msg.to)
+        }
+        if !bytes.HasPrefix(msg.message, []byte("NOTIFY * HTTP/1.1\r\n")) {
+            t.Errorf("secret %q: sent %q", secret, msg.message)
+        }
+        if got := messageHeader(msg.message, relayHeader); got != b.id {
+            t.Errorf("secret %q: %s %q, want %q", secret, relayHeader, got, b.id)
+        }
+
+        cancel()
+        for i := 0; i < 2; i++ {
+            if err := <- done; err != nil {
+                t.Errorf("secret %q: Run: %v", secret, err)
+            }
+        }
+    }
+}
+
+func TestTunnelWrongSecret(t *testing.T) {
+    left, right := net.Pipe()
+    a, b := testTunnel(left, "guess"), testTunnel(right, "s3cret")
+    go drain(left)
+    go a.write(tunnelFrame{Kind: "hello"})
+    done := make(chan error, 1)
+    go func () { done <- b.Run(context.Background()) }()
+    select {
+    case err := <- done:
+        if err == nil || !strings.Contains(err.Error(), "failed authentication") {
+            t.Errorf("got %v, want an authentication failure", err)
+        }
+    case <- time.After(5 * time.Second):
+        t.Fatal("a peer with the wrong secret was kept")
+    }
+}
+
+// Reads what a tunnel sends until the pipe closes, so its send loop isn't stuck.
+func drain(conn net.Conn) {
+    buf := make([]byte, 4096)
+    for {
+        if _, err := conn.Read(buf); err != nil {
+            return
+        }
+    }
+}
+
+func TestTunnelOversizedFrame(t *testing.T) {
+    left, right := net.Pipe()
+    tun := testTunnel(right, "")
+    go func () {
+        // never decoded, so it needn't be JSON
+        left.Write(bytes.Repeat([]byte("x"), maxTunnelFrame + 10))
+    }()
+    go drain(left)
+    done := make(chan error, 1)
+    go func () { done <- tun.Run(context.Background()) }()
+    select {
+    case err := <- done:
+        if err == nil || !strings.Contains(err.Error(), "over 65507 bytes") {
+            t.Errorf("got %v, want the frame refused", err)
+        }
+    case <- time.After(5 * time.Second):
+        t.Fatal("the frame was not refused")
+    }
+}
+
+func TestTunnelAccept(t *testing.T) {
+    left, right := net.Pipe()
+    defer left.Close()
+    defer right.Close()
+    tun := testTunnel(right, "s3cret")
+    peer := testTunnel(left, "s3cret")
+    from := right.RemoteAddr()
+
+    now := time.Now()
+    signed := func (f tunnelFrame, signer *Tunnel, nonce []byte) tunnelFrame {
+        f.Mac = signer.sign(f, nonce)
+        return f
+    }
+    notify := tunnelFrame{Kind: "notify", Message: []byte(testNotify), Time: now.Unix(), Seq: 10}
+
+    if !tun.accept(signed(notify, peer, tun.nonce), from) {
+        t.Fatal("a signed frame was refused")
+    }
+    later := notify
+    later.Seq = 11
+
+    tests := []struct {
+        name        string
+        f           tunnelFrame
+    }{
+        {"replay", signed(notify, peer, tun.nonce)},
+        {"older", func () tunnelFrame { f := notify; f.Seq = 9; return signed(f, peer, tun.nonce) }()},
+        {"unsigned", later},
+        {"bad MAC", func () tunnelFrame { f := signed(later, peer, tun.nonce); f.Mac[0] ^= 1; return f }()},
+        {"changed after signing", func () tunnelFrame { f := signed(later, peer, tun.nonce); f.Message = []byte("NOTIFY * HTTP/1.1\r\n\r\n"); return f }()},
+        {"for another connection", signed(later, peer, []byte("0123456789abcdef"))},
+        {"other secret", signed(later, testTunnel(left, "guess"), tun.nonce)},
+        {"stale", func () tunnelFrame { f := later; f.Time = now.Add(-maxFrameSkew - time.Minute).Unix(); return signed(f, peer, tun.nonce) }()},
+        {"from the future", func () tunnelFrame { f := later; f.Time = now.Add(maxFrameSkew + time.Minute).Unix(); return signed(f, peer, tun.nonce) }()},
+    }
+    for _, tt := range tests {
+        if tun.accept(tt.f, from) {
+            t.Errorf("%s: accepted", tt.name)
+        }
+    }
+
+    if !tun.accept(signed(later, peer, tun.nonce), from) {
+        t.Error("the next frame was refused")
+    }
+    // hellos are signed for no nonce, as the peer's isn't known yet
+    hello := tunnelFrame{Kind: "hello", Nonce: peer.nonce, Time: now.Unix(), Seq: 12}
+    if !tun.accept(signed(hello, peer, nil), from) {
+        t.Error("a signed hello was refused")
+    }
+}
@@ -0,0 +1,262 @@
+package gossdp
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+)
+
+
+// The kind of resource a search target (ST) or notification type (NT) refers to.
+type TargetKind int
+
+const (
+    // Anything we could not classify. Eg. vendor targets such as roku:ecp
+    TargetOther TargetKind = iota
+    // ssdp:all
+    TargetAll
+    // upnp:rootdevice
+    TargetRootDevice
+    // uuid:device-UUID
+    TargetUuid
+    // urn:domain-name:device:deviceType:v
+    TargetDevice
+    // urn:domain-name:service:serviceType:v
+    TargetService
+    // urn:domain-name:kind:type:v, for vendor kinds other than device or service
+    TargetUrn
+)
+
+var (
+    ErrEmptyTarget = errors.New("Empty search target")
+    ErrInvalidUrn = errors.New("Invalid urn search target. Expected urn:domain-name:kind:type:v")
+    ErrInvalidUuidTarget = errors.New("Invalid uuid search target. Expected uuid:device-UUID")
+    ErrInvalidUsn = errors.New("Invalid USN. Expected uuid:device-UUID[::search-target]")
+)
+
+func (k TargetKind) String() string {
+    switch k {
+    case TargetAll:
+        return "all"
+    case TargetRootDevice:
+        return "rootdevice"
+    case TargetUuid:
+        return "uuid"
+    case TargetDevice:
+        return "device"
+    case TargetService:
+        return "service"
+    case TargetUrn:
+        return "urn"
+    }
+    return "other"
+}
+
+// A parsed search target (ST) or notification type (NT).
+//
+//  ssdp:all
+//  upnp:rootdevice
+//  uuid:device-UUID
+//  urn:domain-name:device:deviceType:v
+//  urn:domain-name:service:serviceType:v
+//
+// Targets that do not follow these forms are kept verbatim with the kind TargetOther.
+type SearchTarget struct {
+    // What the target refers to
+    Kind            TargetKind
+    // The device UUID of a uuid: target
+    Uuid            string
+    // The domain name of a urn: target. Eg. schemas-upnp-org
+    Domain          string
+    // The second part of a urn: target. "device", "service", or a vendor defined kind
+    Category        string
+    // The device or service type of a urn: target. Eg. MediaServer
+    Type            string
+    // The version of a urn: target
+    Version         int
+
+    raw             string
+}
+
+// Parses a search target. Surrounding whitespace and quotes are ignored.
+func ParseSearchTarget(st string) (SearchTarget, error) {
+    st = strings.Trim(strings.TrimSpace(st), `"`)
+    if st == "" {
+        return SearchTarget{}, ErrEmptyTarget
+    }
+    switch {
+    case strings.EqualFold(st, "ssdp:all"):
+        return SearchTarget{Kind: TargetAll}, nil
+    case strings.EqualFold(st, "upnp:rootdevice"):
+        return SearchTarget{Kind: TargetRootDevice}, nil
+    case hasPrefixFold(st, "uuid:"):
+        uuid := st[len("uuid:"):]
+        if uuid == "" || strings.Contains(uuid, ":") {
+            return SearchTarget{}, ErrInvalidUuidTarget
+        }
+        return SearchTarget{Kind: TargetUuid, Uuid: uuid}, nil
+    case hasPrefixFold(st, "urn:"):
+        parts := strings.Split(st, ":")
+        if len(parts) != 5 || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+            return SearchTarget{}, ErrInvalidUrn
+        }
+        version, err := strconv.Atoi(parts[4])
+        if err != nil || version < 1 {
+            return SearchTarget{}, ErrInvalidUrn
+        }
+        t := SearchTarget{
+            Kind            : TargetUrn,
+            Domain          : parts[1],
+            Category        : parts[2],
+            Type            : parts[3],
+            Version         : version,
+        }
+        // the case is kept, but ignored, as matchSearch ignores it
+        if strings.EqualFold(parts[2], "device") {
+            t.Kind = TargetDevice
+        } else if strings.EqualFold(parts[2], "service") {
+            t.Kind = TargetService
+        }
+        return t, nil
+    }
+    return SearchTarget{Kind: TargetOther, raw: st}, nil
+}
+
+// Parses a search target as received from the network.
+// Malformed targets are kept verbatim as TargetOther.
+func toSearchTarget(st string) SearchTarget {
+    t, err := ParseSearchTarget(st)
+    if err != nil {
+        return SearchTarget{Kind: TargetOther, raw: strings.TrimSpace(st)}
+    }
+    return t
+}
+
+// Creates a urn:domain-name:device:deviceType:v target
+func DeviceTarget(domain, deviceType string, version int) SearchTarget {
+    return SearchTarget{Kind: TargetDevice, Domain: domain, Category: "device", Type: deviceType, Version: version}
+}
+
+// Creates a urn:domain-name:service:serviceType:v target
+func ServiceTarget(domain, serviceType string, version int) SearchTarget {
+    return SearchTarget{Kind: TargetService, Domain: domain, Category: "service", Type: serviceType, Version: version}
+}
+
+// Creates a uuid:device-UUID target
+func UuidTarget(uuid string) SearchTarget {
+    return SearchTarget{Kind: TargetUuid, Uuid: uuid}
+}
+
+// True if this is a urn: target. Only those are versioned.
+func (t SearchTarget) IsUrn() bool {
+    return t.Kind == TargetDevice || t.Kind == TargetService || t.Kind == TargetUrn
+}
+
+// True if this target was never set.
+func (t SearchTarget) IsZero() bool {
+    return t.Kind == TargetOther && t.raw == ""
+}
+
+// Formats the target as it is sent in ST and NT headers.
+func (t SearchTarget) String() string {
+    switch t.Kind {
+    case TargetAll:
+        return "ssdp:all"
+    case TargetRootDevice:
+        return "upnp:rootdevice"
+    case TargetUuid:
+        return "uuid:" + t.Uuid
+    case TargetDevice, TargetService, TargetUrn:
+        category := t.Category
+        if category == "" {
+            category = t.Kind.String()
+        }
+        return "urn:" + t.Domain + ":" + category + ":" + t.Type + ":" + strconv.Itoa(t.Version)
+    }
+    return t.raw
+}
+
+
+// A parsed Unique Service Name.
+//
+//  uuid:device-UUID
+//  uuid:device-UUID::upnp:rootdevice
+//  uuid:device-UUID::urn:domain-name:device:deviceType:v
+//  uuid:device-UUID::urn:domain-name:service:serviceType:v
+type USN struct {
+    // The device UUID
+    Uuid            string
+    // The notification type this USN names. For a bare uuid:device-UUID USN it is
+    // the uuid: target itself.
+    Target          SearchTarget
+
+    raw             string
+}
+
+// Parses a USN.
+func ParseUSN(usn string) (USN, error) {
+    usn = strings.TrimSpace(usn)
+    if !hasPrefixFold(usn, "uuid:") {
+        return USN{}, ErrInvalidUsn
+    }
+    rest := usn[len("uuid:"):]
+    uuid, target, hasTarget := strings.Cut(rest, "::")
+    if uuid == "" || strings.Contains(uuid, ":") {
+        return USN{}, ErrInvalidUsn
+    }
+    if !hasTarget {
+        return USN{Uuid: uuid, Target: UuidTarget(uuid)}, nil
+    }
+    t, err := ParseSearchTarget(target)
+    if err != nil {
+        return USN{}, err
+    }
+    return USN{Uuid: uuid, Target: t}, nil
+}
+
+// Parses a USN as received from the network.
+// Malformed USNs are kept verbatim, with whatever device UUID we can find.
+func toUSN(usn string) USN {
+    u, err := ParseUSN(usn)
+    if err != nil {
+        u = USN{raw: strings.TrimSpace(usn)}
+        if hasPrefixFold(u.raw, "uuid:") {
+            u.Uuid, _, _ = strings.Cut(u.raw[len("uuid:"):], ":")
+        }
+    }
+    return u
+}
+
+// The device UUID, and what follows it in the USN, for the deprecated DeviceId and Urn
+// message fields. uuid:device-UUID alone has no urn.
+func (u USN) deviceIdAndUrn() (deviceId, urn string) {
+    s := u.String()
+    if !hasPrefixFold(s, "uuid:") {
+        return u.Uuid, s
+    }
+    _, urn, _ = strings.Cut(s[len("uuid:"):], ":")
+    return u.Uuid, strings.TrimPrefix(urn, ":")
+}
+
+// Creates the USN a device advertises for the given target.
+func NewUSN(uuid string, target SearchTarget) USN {
+    return USN{Uuid: uuid, Target: target}
+}
+
+// Formats the USN as it is sent in USN headers.
+func (u USN) String() string {
+    if u.raw != "" {
+        return u.raw
+    }
+    if u.Uuid == "" {
+        return ""
+    }
+    if u.Target.IsZero() || (u.Target.Kind == TargetUuid && strings.EqualFold(u.Target.Uuid, u.Uuid)) {
+        return "uuid:" + u.Uuid
+    }
+    return "uuid:" + u.Uuid + "::" + u.Target.String()
+}
+
+func hasPrefixFold(s, prefix string) bool {
+    return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
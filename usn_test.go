@@ -0,0 +1,141 @@
+package gossdp
+
+import (
+    "strings"
+    "testing"
+)
+
+
+func TestParseSearchTarget(t *testing.T) {
+    tests := []struct {
+        st          string
+        want        SearchTarget
+        err         error
+    }{
+        {"ssdp:all", SearchTarget{Kind: TargetAll}, nil},
+        {"SSDP:ALL", SearchTarget{Kind: TargetAll}, nil},
+        {` "ssdp:all" `, SearchTarget{Kind: TargetAll}, nil},
+        {"upnp:rootdevice", SearchTarget{Kind: TargetRootDevice}, nil},
+        {"uuid:2fac1234-31f8-11b4-a222-08002b34c003", UuidTarget("2fac1234-31f8-11b4-a222-08002b34c003"), nil},
+        {"urn:schemas-upnp-org:device:MediaServer:1", DeviceTarget("schemas-upnp-org", "MediaServer", 1), nil},
+        {"urn:schemas-upnp-org:service:ContentDirectory:4", ServiceTarget("schemas-upnp-org", "ContentDirectory", 4), nil},
+        {"urn:schemas-upnp-org:Device:MediaServer:1", SearchTarget{Kind: TargetDevice, Domain: "schemas-upnp-org", Category: "Device", Type: "MediaServer", Version: 1}, nil},
+        {"urn:dial-multiscreen-org:service:dial:1", ServiceTarget("dial-multiscreen-org", "dial", 1), nil},
+        {"urn:example-com:thing:Fridge:2", SearchTarget{Kind: TargetUrn, Domain: "example-com", Category: "thing", Type: "Fridge", Version: 2}, nil},
+        {"roku:ecp", SearchTarget{Kind: TargetOther, raw: "roku:ecp"}, nil},
+        {"", SearchTarget{}, ErrEmptyTarget},
+        {`""`, SearchTarget{}, ErrEmptyTarget},
+        {"uuid:", SearchTarget{}, ErrInvalidUuidTarget},
+        {"uuid:abc::upnp:rootdevice", SearchTarget{}, ErrInvalidUuidTarget},
+        {"urn:schemas-upnp-org:device:MediaServer", SearchTarget{}, ErrInvalidUrn},
+        {"urn:schemas-upnp-org:device:MediaServer:0", SearchTarget{}, ErrInvalidUrn},
+        {"urn:schemas-upnp-org:device:MediaServer:x", SearchTarget{}, ErrInvalidUrn},
+        {"urn::device:MediaServer:1", SearchTarget{}, ErrInvalidUrn},
+        {"urn:schemas-upnp-org:device:MediaServer:1:extra", SearchTarget{}, ErrInvalidUrn},
+    }
+    for _, tt := range tests {
+        got, err := ParseSearchTarget(tt.st)
+        if err != tt.err {
+            t.Errorf("ParseSearchTarget(%q): error %v, want %v", tt.st, err, tt.err)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("ParseSearchTarget(%q) = %+v, want %+v", tt.st, got, tt.want)
+        }
+    }
+}
+
+func TestSearchTargetString(t *testing.T) {
+    for _, st := range []string{
+        "ssdp:all",
+        "upnp:rootdevice",
+        "uuid:2fac1234-31f8-11b4-a222-08002b34c003",
+        "urn:schemas-upnp-org:device:MediaServer:1",
+        "urn:schemas-upnp-org:service:ContentDirectory:4",
+        "urn:example-com:thing:Fridge:2",
+        "roku:ecp",
+    } {
+        got, err := ParseSearchTarget(st)
+        if err != nil {
+            t.Errorf("ParseSearchTarget(%q): %v", st, err)
+            continue
+        }
+        if got.String() != st {
+            t.Errorf("ParseSearchTarget(%q).String() = %q", st, got.String())
+        }
+    }
+}
+
+func TestParseUSN(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    tests := []struct {
+        usn         string
+        want        USN
+        err         error
+    }{
+        {"uuid:" + uuid, USN{Uuid: uuid, Target: UuidTarget(uuid)}, nil},
+        {"UUID:" + uuid, USN{Uuid: uuid, Target: UuidTarget(uuid)}, nil},
+        {"uuid:" + uuid + "::upnp:rootdevice", USN{Uuid: uuid, Target: SearchTarget{Kind: TargetRootDevice}}, nil},
+        {"uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer:1", USN{Uuid: uuid, Target: DeviceTarget("schemas-upnp-org", "MediaServer", 1)}, nil},
+        {"uuid:" + uuid + "::urn:schemas-upnp-org:service:ContentDirectory:4", USN{Uuid: uuid, Target: ServiceTarget("schemas-upnp-org", "ContentDirectory", 4)}, nil},
+        {" uuid:" + uuid + "::urn:schemas-upnp-org:service:ContentDirectory:4 ", USN{Uuid: uuid, Target: ServiceTarget("schemas-upnp-org", "ContentDirectory", 4)}, nil},
+        {uuid, USN{}, ErrInvalidUsn},
+        {"uuid:", USN{}, ErrInvalidUsn},
+        {"uuid:::upnp:rootdevice", USN{}, ErrInvalidUsn},
+        {"uuid:" + uuid + ":upnp:rootdevice", USN{}, ErrInvalidUsn},
+        {"uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer", USN{}, ErrInvalidUrn},
+        {"uuid:" + uuid + "::", USN{}, ErrEmptyTarget},
+    }
+    for _, tt := range tests {
+        got, err := ParseUSN(tt.usn)
+        if err != tt.err {
+            t.Errorf("ParseUSN(%q): error %v, want %v", tt.usn, err, tt.err)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("ParseUSN(%q) = %+v, want %+v", tt.usn, got, tt.want)
+        }
+    }
+}
+
+func TestUSNDeviceIdAndUrn(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    tests := []struct {
+        usn         string
+        deviceId    string
+        urn         string
+    }{
+        {"uuid:" + uuid, uuid, ""},
+        {"uuid:" + uuid + "::upnp:rootdevice", uuid, "upnp:rootdevice"},
+        {"uuid:" + uuid + "::urn:schemas-upnp-org:device:MediaServer:1", uuid, "urn:schemas-upnp-org:device:MediaServer:1"},
+        {"uuid:" + uuid + ":upnp:rootdevice", uuid, "upnp:rootdevice"},
+        {"roku:ecp", "", "roku:ecp"},
+    }
+    for _, tt := range tests {
+        deviceId, urn := toUSN(tt.usn).deviceIdAndUrn()
+        if deviceId != tt.deviceId || urn != tt.urn {
+            t.Errorf("%s: got %q %q, want %q %q", tt.usn, deviceId, urn, tt.deviceId, tt.urn)
+        }
+    }
+}
+
+func TestUSNString(t *testing.T) {
+    const uuid = "2fac1234-31f8-11b4-a222-08002b34c003"
+    tests := []struct {
+        usn         USN
+        want        string
+    }{
+        {NewUSN(uuid, UuidTarget(uuid)), "uuid:" + uuid},
+        {NewUSN(uuid, SearchTarget{}), "uuid:" + uuid},
+        {NewUSN(uuid, UuidTarget(strings.ToUpper(uuid))), "uuid:" + uuid},
+        {NewUSN(uuid, SearchTarget{Kind: TargetRootDevice}), "uuid:" + uuid + "::upnp:rootdevice"},
+        {NewUSN(uuid, ServiceTarget("schemas-upnp-org", "ContentDirectory", 4)), "uuid:" + uuid + "::urn:schemas-upnp-org:service:ContentDirectory:4"},
+        // malformed USNs from the network are kept as they came
+        {toUSN("uuid:" + uuid + ":upnp:rootdevice"), "uuid:" + uuid + ":upnp:rootdevice"},
+    }
+    for _, tt := range tests {
+        if got := tt.usn.String(); got != tt.want {
+            t.Errorf("%+v.String() = %q, want %q", tt.usn, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,132 @@
+package gossdp
+
+import (
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+
+// Returned by AdvertiseServer for a DeviceUuid that is not a UUID.
+var ErrInvalidDeviceUuid = errors.New("Invalid device UUID. Expected 8-4-4-4-12 hex digits, eg. 2fac1234-31f8-11b4-a222-08002b34c003")
+
+// The namespace of the name based UUIDs DeviceUuid makes.
+const DeviceUuidNamespace = "9d7ba1f4-4c53-5cb2-8e3e-6a1f0b25d8c7"
+
+// Generates a random (version 4) UUID.
+func NewUuid() string {
+    var u [16]byte
+    rand.Read(u[:])
+    u[6] = u[6] & 0x0f | 0x40
+    u[8] = u[8] & 0x3f | 0x80
+    return formatUuid(u)
+}
+
+// Derives a name based (version 5) UUID from a namespace UUID and a name, as RFC 4122
+// describes. The same namespace and name always give the same UUID.
+func NameUuid(namespace, name string) (string, error) {
+    ns, ok := parseUuid(namespace)
+    if !ok {
+        return "", ErrInvalidDeviceUuid
+    }
+    h := sha1.New()
+    h.Write(ns[:])
+    h.Write([]byte(name))
+    var u [16]byte
+    copy(u[:], h.Sum(nil))
+    u[6] = u[6] & 0x0f | 0x50
+    u[8] = u[8] & 0x3f | 0x80
+    return formatUuid(u), nil
+}
+
+// A UUID for a product running on the machine with the given MAC address.
+// It stays the same across restarts and reinstalls, and differs between machines.
+func DeviceUuid(mac net.HardwareAddr, product string) string {
+    u, _ := NameUuid(DeviceUuidNamespace, mac.String() + "/" + product)
+    return u
+}
+
+// Like DeviceUuid, with the MAC address of the first interface that is up and not loopback.
+func LocalDeviceUuid(product string) (string, error) {
+    interfaces, err := net.Interfaces()
+    if err != nil {
+        return "", err
+    }
+    for _, iface := range interfaces {
+        if iface.Flags & net.FlagUp == 0 || iface.Flags & net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+            continue
+        }
+        return DeviceUuid(iface.HardwareAddr, product), nil
+    }
+    return "", errors.New("No network interface with a MAC address")
+}
+
+// True if uuid is 32 hex digits in the 8-4-4-4-12 form. The version is not checked,
+// as plenty of devices use UUIDs that are not RFC 4122 ones.
+func ValidUuid(uuid string) bool {
+    _, ok := parseUuid(uuid)
+    return ok
+}
+
+// Returns the UUID kept in the file at path. If there is none, it calls generate
+// and saves what it returns there, so the device keeps its UUID across restarts.
+//
+//      uuid, err := gossdp.PersistentUuid("/var/lib/myapp/uuid", gossdp.NewUuid)
+func PersistentUuid(path string, generate func() string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err == nil {
+        uuid := strings.TrimSpace(string(data))
+        if !ValidUuid(uuid) {
+            return "", fmt.Errorf("%s: %w", path, ErrInvalidDeviceUuid)
+        }
+        return uuid, nil
+    }
+    if !errors.Is(err, fs.ErrNotExist) {
+        return "", err
+    }
+
+    uuid := generate()
+    if !ValidUuid(uuid) {
+        return "", ErrInvalidDeviceUuid
+    }
+    f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) + ".tmp*")
+    if err != nil {
+        return "", err
+    }
+    defer os.Remove(f.Name())
+    if _, err := f.WriteString(uuid + "\n"); err != nil {
+        f.Close()
+        return "", err
+    }
+    if err := f.Close(); err != nil {
+        return "", err
+    }
+    if err := os.Rename(f.Name(), path); err != nil {
+        return "", err
+    }
+    return uuid, nil
+}
+
+func parseUuid(uuid string) ([16]byte, bool) {
+    var u [16]byte
+    if len(uuid) != 36 || uuid[8] != '-' || uuid[13] != '-' || uuid[18] != '-' || uuid[23] != '-' {
+        return u, false
+    }
+    digits := uuid[0:8] + uuid[9:13] + uuid[14:18] + uuid[19:23] + uuid[24:36]
+    if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+        return u, false
+    }
+    return u, true
+}
+
+func formatUuid(u [16]byte) string {
+    h := hex.EncodeToString(u[:])
+    return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,95 @@
+package gossdp
+
+import (
+    "errors"
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+
+// The version nibble and variant bits of a UUID in the 8-4-4-4-12 form.
+func uuidVersion(t *testing.T, uuid string) (version, variant byte) {
+    u, ok := parseUuid(uuid)
+    if !ok {
+        t.Fatalf("%q is not a UUID", uuid)
+    }
+    return u[6] >> 4, u[8] >> 6
+}
+
+func TestNameUuid(t *testing.T) {
+    // RFC 4122 Appendix B, with its errata, and RFC 9562 Appendix A.4
+    got, err := NameUuid("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "www.example.com")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := "2ed6657d-e927-568b-95e1-2665a8aea6a2"; got != want {
+        t.Errorf("got %s, want %s", got, want)
+    }
+    if version, variant := uuidVersion(t, got); version != 5 || variant != 2 {
+        t.Errorf("%s: version %d, variant %d, want 5 and 2", got, version, variant)
+    }
+
+    other, _ := NameUuid("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "www.example.org")
+    if other == got {
+        t.Errorf("www.example.org got the same UUID as www.example.com")
+    }
+
+    for _, namespace := range []string{"", "6ba7b810-9dad-11d1-80b4-00c04fd430c", "6ba7b8109dad11d180b400c04fd430c8", "6ba7b810-9dad-11d1-80b4-00c04fd430cg"} {
+        if _, err := NameUuid(namespace, "www.example.com"); !errors.Is(err, ErrInvalidDeviceUuid) {
+            t.Errorf("namespace %q: got %v, want ErrInvalidDeviceUuid", namespace, err)
+        }
+    }
+}
+
+func TestNewUuid(t *testing.T) {
+    seen := make(map[string]bool)
+    for i := 0; i < 100; i++ {
+        uuid := NewUuid()
+        if version, variant := uuidVersion(t, uuid); version != 4 || variant != 2 {
+            t.Fatalf("%s: version %d, variant %d, want 4 and 2", uuid, version, variant)
+        }
+        if seen[uuid] {
+            t.Fatalf("%s came up twice", uuid)
+        }
+        seen[uuid] = true
+    }
+}
+
+func TestDeviceUuid(t *testing.T) {
+    mac, _ := net.ParseMAC("00:11:22:33:44:55")
+    other, _ := net.ParseMAC("00:11:22:33:44:56")
+    uuid := DeviceUuid(mac, "media")
+    if uuid != DeviceUuid(mac, "media") {
+        t.Error("not the same for the same machine and product")
+    }
+    if uuid == DeviceUuid(other, "media") || uuid == DeviceUuid(mac, "printer") {
+        t.Error("the same for another machine or product")
+    }
+    if version, variant := uuidVersion(t, uuid); version != 5 || variant != 2 {
+        t.Errorf("%s: version %d, variant %d, want 5 and 2", uuid, version, variant)
+    }
+}
+
+func TestPersistentUuid(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "uuid")
+    first, err := PersistentUuid(path, NewUuid)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, err := PersistentUuid(path, func () string {
+        t.Error("generated a UUID though one was saved")
+        return NewUuid()
+    })
+    if err != nil || again != first {
+        t.Errorf("got %s, %v, want %s", again, err, first)
+    }
+
+    if err := os.WriteFile(path, []byte("not a uuid\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := PersistentUuid(path, NewUuid); !errors.Is(err, ErrInvalidDeviceUuid) {
+        t.Errorf("a bad file: got %v, want ErrInvalidDeviceUuid", err)
+    }
+}